@@ -22,7 +22,11 @@ func main() {
 	}
 
 	lex := lexer.NewLexer(template)
-	tokens := lex.Lex()
+	tokens, err := lex.Lex()
+	if err != nil {
+		fmt.Printf("Syntax error: %v\n", err)
+		os.Exit(1)
+	}
 
 	comp := compiler.NewCompiler(tokens)
 	instructions, constants, err := comp.Compile(tokens)
@@ -0,0 +1,251 @@
+// Package access reads template values: the fields and entries of maps and
+// structs, and the elements of iterators, through reflection where needed.
+package access
+
+import (
+	"fmt"
+	"iter"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flothq/swap/internal/values"
+)
+
+// Path walks the segments of a path starting at value. Anything that cannot
+// be walked, such as a missing key or a field on a string, resolves to nil.
+func Path(value interface{}, segments []string) interface{} {
+	for _, segment := range segments {
+		next, ok := Lookup(value, segment)
+		if !ok {
+			return nil
+		}
+		value = next
+	}
+	return value
+}
+
+// Lookup returns the entry or field named key. ok is false when value is not
+// something with named members, as opposed to one without a member called key.
+func Lookup(value interface{}, key string) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v[key], true
+	case map[string]string:
+		if s, ok := v[key]; ok {
+			return s, true
+		}
+		return nil, true
+	case nil:
+		return nil, false
+	}
+	return LookupValue(reflect.ValueOf(value), key)
+}
+
+// LookupValue is Lookup for a reflect.Value.
+func LookupValue(rv reflect.Value, key string) (interface{}, bool) {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, rv.Kind() == reflect.Pointer
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		entry := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+		if !entry.IsValid() {
+			return nil, true
+		}
+		return entry.Interface(), true
+	case reflect.Struct:
+		index, ok := fieldsOf(rv.Type())[key]
+		if !ok {
+			return nil, true
+		}
+		var field reflect.Value
+		if len(index) == 1 {
+			field = rv.Field(index[0])
+		} else {
+			var err error
+			if field, err = rv.FieldByIndexErr(index); err != nil {
+				return nil, true
+			}
+		}
+		if !field.CanInterface() {
+			return nil, true
+		}
+		return field.Interface(), true
+	default:
+		return nil, false
+	}
+}
+
+// fieldCache maps each struct type to the index of its fields by name, so
+// reflection over the struct's layout happens once per type.
+var fieldCache sync.Map
+
+// fieldsOf returns the exported fields of a struct type, including promoted
+// ones, by the name set in their `swap:"name"` tag or else by their Go name.
+// Fields tagged `swap:"-"` are left out.
+func fieldsOf(t reflect.Type) map[string][]int {
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.(map[string][]int)
+	}
+
+	fields := make(map[string][]int)
+	for _, sf := range reflect.VisibleFields(t) {
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Name
+		if tag, ok := sf.Tag.Lookup("swap"); ok {
+			tag, _, _ = strings.Cut(tag, ",")
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		if index, ok := fields[name]; ok && len(index) <= len(sf.Index) {
+			continue
+		}
+		fields[name] = sf.Index
+	}
+
+	actual, _ := fieldCache.LoadOrStore(t, fields)
+	return actual.(map[string][]int)
+}
+
+// Seq adapts any iter.Seq[V] or iter.Seq2[K, V] to an iter.Seq2[any, any].
+// Single-value sequences are keyed by their index. ok is false when rv is not
+// a function with the signature of either.
+func Seq(rv reflect.Value) (iter.Seq2[interface{}, interface{}], bool) {
+	t := rv.Type()
+	if t.NumIn() != 1 || t.NumOut() != 0 {
+		return nil, false
+	}
+	yieldType := t.In(0)
+	if yieldType.Kind() != reflect.Func || yieldType.NumOut() != 1 || yieldType.Out(0).Kind() != reflect.Bool {
+		return nil, false
+	}
+	if n := yieldType.NumIn(); n != 1 && n != 2 {
+		return nil, false
+	}
+
+	return func(yield func(interface{}, interface{}) bool) {
+		i := 0
+		fn := reflect.MakeFunc(yieldType, func(args []reflect.Value) []reflect.Value {
+			var more bool
+			if len(args) == 1 {
+				more = yield(i, args[0].Interface())
+			} else {
+				more = yield(args[0].Interface(), args[1].Interface())
+			}
+			i++
+			return []reflect.Value{reflect.ValueOf(more)}
+		})
+		rv.Call([]reflect.Value{fn})
+	}, true
+}
+
+// MapKeys returns the keys of a map in the order templates visit them, both
+// in range loops and in collection functions: see CompareKeys.
+func MapKeys(rv reflect.Value) []reflect.Value {
+	keys := rv.MapKeys()
+	if rv.Type().Key().Kind() == reflect.String {
+		slices.SortFunc(keys, func(a, b reflect.Value) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		return keys
+	}
+	slices.SortFunc(keys, func(a, b reflect.Value) int {
+		return CompareKeys(a.Interface(), b.Interface())
+	})
+	return keys
+}
+
+// CompareKeys orders map keys: numbers numerically, strings lexically, times
+// chronologically and anything else, including keys of different types, by
+// their formatted text.
+func CompareKeys(a, b interface{}) int {
+	if na, ok := values.ToNumber(a); ok {
+		if nb, ok := values.ToNumber(b); ok {
+			return values.CompareNumbers(na, nb)
+		}
+	}
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// Exists reports whether every segment of a path is present starting at
+// value, as opposed to Path resolving to nil because a key is missing, a
+// field does not exist or a value along the way is nil. A key that is present
+// with a nil value exists.
+func Exists(value interface{}, segments []string) bool {
+	for _, segment := range segments {
+		next, ok := find(value, segment)
+		if !ok {
+			return false
+		}
+		value = next
+	}
+	return true
+}
+
+// find is Lookup that also reports whether key is present.
+func find(value interface{}, key string) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		next, ok := v[key]
+		return next, ok
+	case map[string]string:
+		next, ok := v[key]
+		return next, ok
+	case nil:
+		return nil, false
+	}
+
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		if !rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).IsValid() {
+			return nil, false
+		}
+	case reflect.Struct:
+		index, ok := fieldsOf(rv.Type())[key]
+		if !ok {
+			return nil, false
+		}
+		if _, err := rv.FieldByIndexErr(index); err != nil {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	return LookupValue(rv, key)
+}
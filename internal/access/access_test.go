@@ -0,0 +1,117 @@
+package access
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	type named string
+	type inner struct{ Value int }
+	type outer struct {
+		Inner  *inner
+		Any    interface{}
+		hidden int
+	}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		key      string
+		expected interface{}
+		ok       bool
+	}{
+		{name: "map", value: map[string]interface{}{"a": 1}, key: "a", expected: 1, ok: true},
+		{name: "missing map key", value: map[string]interface{}{}, key: "a", expected: nil, ok: true},
+		{name: "string map", value: map[string]string{"a": "x"}, key: "a", expected: "x", ok: true},
+		{name: "missing string map key", value: map[string]string{}, key: "a", expected: nil, ok: true},
+		{name: "named key map", value: map[named]int{"a": 2}, key: "a", expected: 2, ok: true},
+		{name: "int key map", value: map[int]int{1: 2}, key: "1", expected: nil, ok: false},
+		{name: "struct field", value: outer{Any: "x"}, key: "Any", expected: "x", ok: true},
+		{name: "struct pointer field", value: &inner{Value: 3}, key: "Value", expected: 3, ok: true},
+		{name: "unexported field", value: outer{hidden: 1}, key: "hidden", expected: nil, ok: true},
+		{name: "unknown field", value: outer{}, key: "Missing", expected: nil, ok: true},
+		{name: "nil struct pointer", value: (*inner)(nil), key: "Value", expected: nil, ok: true},
+		{name: "string", value: "abc", key: "a", expected: nil, ok: false},
+		{name: "nil", value: nil, key: "a", expected: nil, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Lookup(tt.value, tt.key)
+			if got != tt.expected || ok != tt.ok {
+				t.Errorf("Lookup(%#v, %q) = %#v, %v, want %#v, %v", tt.value, tt.key, got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestExists(t *testing.T) {
+	type inner struct{ Value *int }
+	type outer struct {
+		Inner  *inner
+		Tagged string `swap:"tagged"`
+	}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		path     string
+		expected bool
+	}{
+		{name: "map key", value: map[string]interface{}{"a": 1}, path: "a", expected: true},
+		{name: "nil map value", value: map[string]interface{}{"a": nil}, path: "a", expected: true},
+		{name: "missing map key", value: map[string]interface{}{}, path: "a", expected: false},
+		{name: "missing string map key", value: map[string]string{"b": ""}, path: "a", expected: false},
+		{name: "nested", value: map[string]interface{}{"a": map[string]int{"b": 0}}, path: "a.b", expected: true},
+		{name: "missing nested", value: map[string]interface{}{"a": map[string]int{}}, path: "a.b", expected: false},
+		{name: "through nil", value: map[string]interface{}{"a": nil}, path: "a.b", expected: false},
+		{name: "struct field", value: &outer{}, path: "tagged", expected: true},
+		{name: "nil struct field", value: outer{Inner: &inner{}}, path: "Inner.Value", expected: true},
+		{name: "through nil pointer", value: outer{}, path: "Inner.Value", expected: false},
+		{name: "unknown field", value: outer{}, path: "Tagged", expected: false},
+		{name: "field of string", value: map[string]interface{}{"a": "x"}, path: "a.b", expected: false},
+		{name: "empty path", value: nil, path: "", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var segments []string
+			if tt.path != "" {
+				segments = strings.Split(tt.path, ".")
+			}
+			if got := Exists(tt.value, segments); got != tt.expected {
+				t.Errorf("Exists(%#v, %q) = %v, want %v", tt.value, tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMapKeys(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		m        interface{}
+		expected []interface{}
+	}{
+		{name: "strings", m: map[string]int{"b": 1, "a": 2, "B": 3}, expected: []interface{}{"B", "a", "b"}},
+		{name: "ints", m: map[int]bool{10: true, 2: true, -1: true}, expected: []interface{}{-1, 2, 10}},
+		{name: "mixed numbers", m: map[interface{}]int{int64(3): 0, 2.5: 0, uint8(1): 0}, expected: []interface{}{uint8(1), 2.5, int64(3)}},
+		{name: "times", m: map[time.Time]int{day.Add(time.Hour): 0, day.In(time.FixedZone("x", 3600*5)): 0}, expected: []interface{}{day.In(time.FixedZone("x", 3600*5)), day.Add(time.Hour)}},
+		{name: "mixed types by text", m: map[interface{}]int{"b": 0, 10: 0, true: 0}, expected: []interface{}{10, "b", true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []interface{}
+			for _, k := range MapKeys(reflect.ValueOf(tt.m)) {
+				got = append(got, k.Interface())
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MapKeys() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -2,17 +2,62 @@ package compiler
 
 import (
 	"fmt"
+	"math"
+	"strings"
 	"sync"
 
+	"github.com/flothq/swap/internal/funcs"
 	"github.com/flothq/swap/internal/lexer"
 	"github.com/flothq/swap/pkg/bytecode"
 )
 
+type blockKind int
+
+const (
+	blockIf blockKind = iota
+	blockRange
+	blockWith
+)
+
+func (k blockKind) String() string {
+	switch k {
+	case blockIf:
+		return "if"
+	case blockRange:
+		return "range"
+	case blockWith:
+		return "with"
+	default:
+		return "unknown"
+	}
+}
+
+// block tracks an open {{if}}, {{range}} or {{with}} until its {{end}} is
+// reached. next is the OpJumpIfFalse of the current branch, or the start of a
+// range or with, that still needs a target (-1 when there is none) and exits
+// are the OpJumps that leave the block once a branch has run. In a range,
+// exits also holds the {{break}}s and continues the {{continue}}s, which jump
+// to its OpLoopEnd.
+type block struct {
+	kind      blockKind
+	next      int
+	exits     []int
+	continues []int
+	hasElse   bool
+}
+
 type Compiler struct {
 	tokens       []lexer.Token
 	instructions []bytecode.Instruction
 	constants    []bytecode.Constant
+	blocks       []block
+	scopes       []scope
+	funcs        *funcs.Table
+	nextSlot     uint16
 	pos          int
+	// linePos and lineNo cache the line of tokens[linePos] for line.
+	linePos int
+	lineNo  int
 }
 
 var compilerPool = sync.Pool{
@@ -21,6 +66,7 @@ var compilerPool = sync.Pool{
 			tokens:       make([]lexer.Token, 0),
 			instructions: make([]bytecode.Instruction, 0),
 			constants:    make([]bytecode.Constant, 0),
+			blocks:       make([]block, 0, 4),
 			pos:          0,
 		}
 	},
@@ -30,41 +76,79 @@ func NewCompiler(tokens []lexer.Token) *Compiler {
 	c := compilerPool.Get().(*Compiler)
 	c.instructions = c.instructions[:0]
 	c.constants = c.constants[:0]
+	c.blocks = c.blocks[:0]
+	c.scopes = c.scopes[:0]
+	c.funcs = funcs.Default()
+	c.nextSlot = 0
 	c.pos = 0
+	c.linePos = 0
+	c.lineNo = 1
 	c.tokens = tokens
+	c.pushScope()
 	return c
 }
 
+// SetFuncs sets the functions templates may call. Calls are compiled to
+// indexes into t, so the program must be run with the same table.
+func (c *Compiler) SetFuncs(t *funcs.Table) {
+	c.funcs = t
+}
+
+// line returns the template line of the current token, counting from 1. The
+// tokens cover the whole template, so it is one more than the number of
+// newlines in the tokens before it.
+func (c *Compiler) line() uint16 {
+	if c.pos < c.linePos {
+		c.linePos, c.lineNo = 0, 1
+	}
+	for ; c.linePos < c.pos && c.linePos < len(c.tokens); c.linePos++ {
+		c.lineNo += strings.Count(c.tokens[c.linePos].Value, "\n")
+	}
+	return uint16(min(c.lineNo, math.MaxUint16))
+}
+
 func (c *Compiler) Release() {
 	c.tokens = c.tokens[:0]
+	c.funcs = nil
+	c.blocks = c.blocks[:0]
+	clear(c.scopes)
+	c.scopes = c.scopes[:0]
 	compilerPool.Put(c)
 }
 
 func (c *Compiler) Compile(tokens []lexer.Token) ([]bytecode.Instruction, []bytecode.Constant, error) {
-	for {
+	for c.pos < len(c.tokens) {
 		token := c.tokens[c.pos]
 		switch token.Type {
 		case lexer.TokenLiteralString:
-			c.constants = append(c.constants, bytecode.Constant{Type: bytecode.ConstString, Value: token.Value})
-			c.emit(bytecode.OpPrintConst, uint8(len(c.constants)-1), 0, 0)
+			c.emit(bytecode.OpPrintConst, c.addConstant(bytecode.ConstString, token.Value), 0, 0)
 			c.pos++
 		case lexer.TokenLDelim:
-			if err := c.compileExpression(); err != nil {
+			if err := c.compileAction(); err != nil {
 				return nil, nil, err
 			}
 		case lexer.TokenEOF:
+			if len(c.blocks) > 0 {
+				return nil, nil, fmt.Errorf("unclosed %s block", c.blocks[len(c.blocks)-1].kind)
+			}
 			c.emit(bytecode.OpHalt, 0, 0, 0)
 			c.pos++
-			return c.instructions, c.constants, nil
+			return c.finish()
 		default:
 			return nil, nil, fmt.Errorf("invalid token in compile: %v, %s", token.Type, token.Value)
 		}
-
-		if c.pos >= len(c.tokens) {
-			break
-		}
 	}
 
+	return c.finish()
+}
+
+func (c *Compiler) finish() ([]bytecode.Instruction, []bytecode.Constant, error) {
+	if len(c.constants) > math.MaxUint16+1 {
+		return nil, nil, fmt.Errorf("template has too many constants: %d", len(c.constants))
+	}
+	if len(c.instructions) > math.MaxUint16 {
+		return nil, nil, fmt.Errorf("template has too many instructions: %d", len(c.instructions))
+	}
 	return c.instructions, c.constants, nil
 }
 
@@ -74,122 +158,422 @@ func (c *Compiler) eatWhitespace() {
 	}
 }
 
-func (c *Compiler) compileExpression() error {
+// current returns the token at the current position, or an EOF token once
+// the input is exhausted.
+func (c *Compiler) current() lexer.Token {
+	if c.pos >= len(c.tokens) {
+		return lexer.Token{Type: lexer.TokenEOF}
+	}
+	return c.tokens[c.pos]
+}
+
+// peekNext returns the first non-space token after the current one without
+// consuming anything.
+func (c *Compiler) peekNext() lexer.Token {
+	for i := c.pos + 1; i < len(c.tokens); i++ {
+		if c.tokens[i].Type != lexer.TokenSpace {
+			return c.tokens[i]
+		}
+	}
+	return lexer.Token{Type: lexer.TokenEOF}
+}
+
+func (c *Compiler) expectRDelim() error {
+	c.eatWhitespace()
+	token := c.current()
+	if token.Type != lexer.TokenRDelim {
+		return fmt.Errorf("expected right delimiter, got %v", token)
+	}
+	c.pos++
+	return nil
+}
+
+func (c *Compiler) compileAction() error {
 	c.pos++
 	c.eatWhitespace()
-	for i := c.pos; i < len(c.tokens); i++ {
-		c.eatWhitespace()
-		token := c.tokens[c.pos]
-		switch token.Type {
-		case lexer.TokenRDelim:
-			c.pos++
-			return nil
-		case lexer.TokenAccessor:
-			c.constants = append(c.constants, bytecode.Constant{Type: bytecode.ConstString, Value: token.Value})
-			c.emit(bytecode.OpResolvePrint, uint8(len(c.constants)-1), 0, 0)
-			c.pos++
-			c.eatWhitespace()
-		case lexer.TokenIdentifier:
-			if token.Value == "end" {
-				c.pos++
-				c.emit(bytecode.OpLoopEnd, 0, 0, 0)
-				c.eatWhitespace()
-			} else if token.Value == "range" {
-				c.pos++
-				if c.pos >= len(c.tokens) {
-
-					return fmt.Errorf("unexpected end of input after 'range'")
-				}
-				c.pos++
-				nextToken := c.tokens[c.pos]
-				if nextToken.Type != lexer.TokenAccessor {
-					fmt.Println(nextToken)
-					return fmt.Errorf("expected identifier after 'range', got %v", nextToken)
-				}
-				c.pos++
-				c.eatWhitespace()
-
-				if c.pos >= len(c.tokens) || c.tokens[c.pos].Type != lexer.TokenRDelim {
-					return fmt.Errorf(
-						fmt.Sprintf("expected right delimiter after 'range', got %v", c.tokens[c.pos]),
-					)
-				}
-				c.constants = append(c.constants, bytecode.Constant{Type: bytecode.ConstString, Value: nextToken.Value})
-				c.emit(bytecode.OpLoopStart, uint8(len(c.constants)-1), 0, 0)
-			} else {
-				if c.pos+1 < len(c.tokens) && c.tokens[c.pos+1].Type == lexer.TokenLParen {
-					tokens, err := c.compileFunctionCall()
-					if err != nil {
-						return err
-					}
-					for i := len(tokens) - 1; i >= 0; i-- {
-						c.instructions = append(c.instructions, tokens[i])
-					}
-				} else {
-					c.constants = append(c.constants, bytecode.Constant{Type: bytecode.ConstString, Value: token.Value})
-					c.emit(bytecode.OpResolvePrint, uint8(len(c.constants)-1), 0, 0)
-					c.pos++
-				}
-			}
-		default:
-			return fmt.Errorf("unexpected token in expression: %v", token)
+
+	token := c.current()
+	if token.Type == lexer.TokenIdentifier {
+		switch token.Value {
+		case "if":
+			return c.compileIf()
+		case "else":
+			return c.compileElse()
+		case "end":
+			return c.compileEnd()
+		case "range":
+			return c.compileRange()
+		case "with":
+			return c.compileWith()
+		case "break", "continue":
+			return c.compileLoopControl(token.Value)
+		}
+	}
+
+	switch {
+	case token.Type == lexer.TokenRDelim:
+		c.pos++
+		return nil
+	case (token.Type == lexer.TokenAccessor || token.Type == lexer.TokenIdentifier && !c.isBareCall()) && c.peekNext().Type == lexer.TokenRDelim:
+		c.emit(bytecode.OpResolvePrint, c.addPath(token.Value), c.line(), 0)
+		c.pos++
+	case token.Type == lexer.TokenVariable && strings.HasPrefix(token.Value, "$.") && c.peekNext().Type == lexer.TokenRDelim:
+		root, _ := rootPath(token.Value)
+		c.emit(bytecode.OpResolvePrint, c.addConstant(bytecode.ConstPath, root), c.line(), 0)
+		c.pos++
+	case token.Type == lexer.TokenVariable && c.peekNext().Type == lexer.TokenOperator && (c.peekNext().Value == ":=" || c.peekNext().Value == "="):
+		if err := c.compileAssignment(); err != nil {
+			return err
+		}
+	default:
+		start := len(c.instructions)
+		if err := c.compilePipeline(); err != nil {
+			return err
 		}
+		c.emit(bytecode.OpPrint, c.printsLookup(start), 0, 0)
 	}
 
-	return fmt.Errorf("unexpected end of input")
+	return c.expectRDelim()
 }
 
-func (c *Compiler) compileFunctionCall() ([]bytecode.Instruction, error) {
-	stack := make([]bytecode.Instruction, 0)
-	count := uint8(0)
+// printsLookup returns 1 when the action compiled from start is a single
+// field or index lookup, as in {{ $user.name }} or {{ .items[0] }}, so that
+// OpPrint can show a missing key as a placeholder, and 0 otherwise.
+func (c *Compiler) printsLookup(start int) uint16 {
+	var unpacked bytecode.UnpackedInstruction
+	for _, instruction := range c.instructions[start:] {
+		unpacked.Unpack(instruction)
+		switch unpacked.Op {
+		case bytecode.OpJump, bytecode.OpJumpIfFalse, bytecode.OpJumpIfFalseOrPop, bytecode.OpJumpIfTrueOrPop, bytecode.OpJumpIfNotNilOrPop:
+			return 0
+		}
+	}
+	if unpacked.Op == bytecode.OpField || unpacked.Op == bytecode.OpIndex {
+		return 1
+	}
+	return 0
+}
 
-	token := c.tokens[c.pos]
+// compileAssignment compiles `$name := pipeline`, which declares a variable
+// in the enclosing block, and `$name = pipeline`, which updates the nearest
+// declared one. Neither prints anything.
+func (c *Compiler) compileAssignment() error {
+	name := c.current().Value
+	if strings.Contains(name, ".") || name == "$" {
+		return fmt.Errorf("cannot assign to %s", name)
+	}
+	c.pos++
+	c.eatWhitespace()
+	declare := c.current().Value == ":="
+	c.pos++
 
-	if token.Type != lexer.TokenIdentifier {
+	if err := c.compilePipeline(); err != nil {
+		return err
+	}
 
-		return nil, fmt.Errorf("expected identifier after 'call', got %v", token)
+	var slot uint16
+	if declare {
+		slot = c.declareVariable(name)
+	} else {
+		var ok bool
+		if slot, ok = c.lookupVariable(name); !ok {
+			return fmt.Errorf("assignment to undeclared variable %s", name)
+		}
 	}
+	c.emit(bytecode.OpStoreVar, slot, 0, 0)
+	return nil
+}
+
+func (c *Compiler) compileIf() error {
+	c.pos++
+	if err := c.compilePipeline(); err != nil {
+		return err
+	}
+	if err := c.expectRDelim(); err != nil {
+		return err
+	}
+	c.blocks = append(c.blocks, block{kind: blockIf, next: c.emitJump(bytecode.OpJumpIfFalse)})
+	c.pushScope()
+	return nil
+}
 
-	if c.tokens[c.pos+1].Type != lexer.TokenLParen {
-		return nil, fmt.Errorf("expected '(' after function name, got %v", c.tokens[c.pos+1])
+func (c *Compiler) compileElse() error {
+	c.pos++
+	if len(c.blocks) == 0 {
+		return fmt.Errorf("unexpected else outside of an if or range block")
+	}
+	b := &c.blocks[len(c.blocks)-1]
+	if b.hasElse {
+		return fmt.Errorf("unexpected else after else")
+	}
+	if b.kind != blockIf {
+		return c.compileBlockElse(b)
 	}
 
-	c.constants = append(c.constants, bytecode.Constant{Type: bytecode.ConstString, Value: token.Value})
-	stack = append(stack, bytecode.PackInstruction(bytecode.OpCall, uint8(len(c.constants)-1), 0, 0))
+	b.exits = append(b.exits, c.emitJump(bytecode.OpJump))
+	c.patchJump(b.next)
+	b.next = -1
+	c.popScope()
+	c.pushScope()
 
+	c.eatWhitespace()
+	if token := c.current(); token.Type == lexer.TokenIdentifier && token.Value == "if" {
+		c.pos++
+		if err := c.compilePipeline(); err != nil {
+			return err
+		}
+		if err := c.expectRDelim(); err != nil {
+			return err
+		}
+		b.next = c.emitJump(bytecode.OpJumpIfFalse)
+		return nil
+	}
+
+	b.hasElse = true
+	return c.expectRDelim()
+}
+
+func (c *Compiler) compileEnd() error {
 	c.pos++
+	if err := c.expectRDelim(); err != nil {
+		return err
+	}
+	if len(c.blocks) == 0 {
+		return fmt.Errorf("unexpected end without an open block")
+	}
+
+	b := c.blocks[len(c.blocks)-1]
+	c.blocks = c.blocks[:len(c.blocks)-1]
+	c.popScope()
+
+	if !b.hasElse {
+		switch b.kind {
+		case blockRange:
+			c.emitLoopEnd(&b)
+		case blockWith:
+			c.emit(bytecode.OpWithEnd, 0, 0, 0)
+		}
+	}
+	if b.next >= 0 {
+		c.patchJump(b.next)
+	}
+	for _, exit := range b.exits {
+		c.patchJump(exit)
+	}
+	return nil
+}
+
+// compileBlockElse closes the body of a range or with and starts the branch
+// that runs when the value is empty, which is where the block start jumps in
+// that case.
+func (c *Compiler) compileBlockElse(b *block) error {
+	c.eatWhitespace()
+	if token := c.current(); token.Type == lexer.TokenIdentifier && token.Value == "if" {
+		return fmt.Errorf("unexpected else if in %s block", b.kind)
+	}
+	if err := c.expectRDelim(); err != nil {
+		return err
+	}
+
+	if b.kind == blockRange {
+		c.emitLoopEnd(b)
+	} else {
+		c.emit(bytecode.OpWithEnd, 0, 0, 0)
+	}
+	b.exits = append(b.exits, c.emitJump(bytecode.OpJump))
+	c.patchJump(b.next)
+	b.next = -1
+	b.hasElse = true
+	c.popScope()
+	c.pushScope()
+	return nil
+}
+
+// compileRange compiles `range .items`, `range $item := .items` and
+// `range $key, $item := .items`, where .items may be any pipeline. The
+// variables are bound at the start of the body so they are refreshed on every
+// iteration. The loop start jumps to its B operand, the {{else}} branch or the
+// end of the block, when there is nothing to iterate.
+func (c *Compiler) compileRange() error {
 	c.pos++
+	c.eatWhitespace()
 
-	for {
-		token := c.tokens[c.pos]
-		switch token.Type {
-		case lexer.TokenAccessor:
-			c.constants = append(c.constants, bytecode.Constant{Type: bytecode.ConstString, Value: token.Value})
-			stack = append(stack, bytecode.PackInstruction(bytecode.OpResolveLoad, count, uint8(len(c.constants)-1), 0))
-			count++
-		case lexer.TokenIdentifier:
-			tokens, err := c.compileFunctionCall()
-			if err != nil {
-				return nil, err
-			}
-			stack = append(stack, tokens...)
-		case lexer.TokenRParen:
-			c.pos++
-			return stack, nil
-		case lexer.TokenLiteralString:
-			c.constants = append(c.constants, bytecode.Constant{Type: bytecode.ConstString, Value: token.Value})
-			stack = append(stack, bytecode.PackInstruction(bytecode.OpLoadConst, count, uint8(len(c.constants)-1), 0))
-			count++
-		case lexer.TokenSpace:
-		case lexer.TokenComma:
+	start := c.pos
+	var names []string
+	for c.current().Type == lexer.TokenVariable {
+		names = append(names, c.current().Value)
+		c.pos++
+		c.eatWhitespace()
+		if c.current().Type != lexer.TokenComma {
+			break
+		}
+		c.pos++
+		c.eatWhitespace()
+	}
+	if len(names) > 2 {
+		return fmt.Errorf("range declares at most two variables, got %d", len(names))
+	}
+	if len(names) > 0 {
+		switch {
+		case c.matchOperator(":="):
+			c.eatWhitespace()
+		case len(names) == 1:
+			// `range $items` ranges over the variable itself.
+			c.pos = start
+			names = nil
 		default:
-			return nil, fmt.Errorf("unexpected token in function call: %v", token)
+			return fmt.Errorf("expected ':=' after range variables, got %v", c.current())
 		}
+	}
 
+	if token := c.current(); token.Type == lexer.TokenAccessor && c.peekNext().Type == lexer.TokenRDelim {
+		line := c.line()
 		c.pos++
+		c.emit(bytecode.OpLoopStart, c.addPath(token.Value), 0, line)
+	} else {
+		if err := c.compilePipeline(); err != nil {
+			return err
+		}
+		c.emit(bytecode.OpLoopStartValue, 0, 0, 0)
+	}
+	loopStart := len(c.instructions) - 1
+	if err := c.expectRDelim(); err != nil {
+		return err
+	}
+
+	c.blocks = append(c.blocks, block{kind: blockRange, next: loopStart})
+	c.pushScope()
+
+	fields := []bytecode.LoopField{bytecode.LoopValue}
+	if len(names) == 2 {
+		fields = []bytecode.LoopField{bytecode.LoopKey, bytecode.LoopValue}
+	}
+	for i, name := range names {
+		if strings.Contains(name, ".") || name == "$" {
+			return fmt.Errorf("invalid range variable %s", name)
+		}
+		c.emit(bytecode.OpLoopMeta, uint16(fields[i]), 0, 0)
+		c.emit(bytecode.OpStoreVar, c.declareVariable(name), 0, 0)
+	}
+	return nil
+}
+
+// emitLoopEnd closes the body of a range, pointing its {{continue}}s at the
+// OpLoopEnd.
+func (c *Compiler) emitLoopEnd(b *block) {
+	for _, at := range b.continues {
+		c.patchJump(at)
+	}
+	c.emit(bytecode.OpLoopEnd, 0, 0, 0)
+}
+
+// compileLoopControl compiles {{break}} and {{continue}} for the innermost
+// running range, which skips ranges whose else branch contains it. A break
+// leaves the loop through OpLoopBreak, which pops it before jumping to the
+// end of the block. The dot of every with body being left is popped first.
+func (c *Compiler) compileLoopControl(keyword string) error {
+	c.pos++
+	if err := c.expectRDelim(); err != nil {
+		return err
+	}
+
+	for i := len(c.blocks) - 1; i >= 0; i-- {
+		b := &c.blocks[i]
+		if b.kind == blockWith && !b.hasElse {
+			c.emit(bytecode.OpWithEnd, 0, 0, 0)
+		}
+		if b.kind != blockRange || b.hasElse {
+			// The else branch of a range runs when its loop did not, so
+			// the statement belongs to an enclosing range.
+			continue
+		}
+		if keyword == "break" {
+			b.exits = append(b.exits, c.emitJump(bytecode.OpLoopBreak))
+		} else {
+			b.continues = append(b.continues, c.emitJump(bytecode.OpJump))
+		}
+		return nil
 	}
+	return fmt.Errorf("unexpected %s outside of a range block", keyword)
 }
 
-func (c *Compiler) emit(op bytecode.OpCode, a, b, d uint8) {
+// compileWith compiles `with pipeline` and `with $name := pipeline`. When the
+// value is truthy it becomes the dot for the body, otherwise OpWithStart jumps
+// to the {{else}} branch or past the block.
+func (c *Compiler) compileWith() error {
+	c.pos++
+	c.eatWhitespace()
+
+	name := ""
+	if token := c.current(); token.Type == lexer.TokenVariable {
+		if next := c.peekNext(); next.Type == lexer.TokenOperator && next.Value == ":=" {
+			if strings.Contains(token.Value, ".") || token.Value == "$" {
+				return fmt.Errorf("invalid with variable %s", token.Value)
+			}
+			name = token.Value
+			c.pos++
+			c.matchOperator(":=")
+		}
+	}
+
+	if err := c.compilePipeline(); err != nil {
+		return err
+	}
+	if err := c.expectRDelim(); err != nil {
+		return err
+	}
+
+	c.blocks = append(c.blocks, block{kind: blockWith, next: c.emitJump(bytecode.OpWithStart)})
+	c.pushScope()
+
+	if name != "" {
+		c.emit(bytecode.OpResolvePush, c.addConstant(bytecode.ConstPath, bytecode.Path{}), 0, 0)
+		c.emit(bytecode.OpStoreVar, c.declareVariable(name), 0, 0)
+	}
+	return nil
+}
+
+func (c *Compiler) inRange() bool {
+	for _, b := range c.blocks {
+		if b.kind == blockRange {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compiler) addConstant(constType bytecode.ConstantType, value interface{}) uint16 {
+	c.constants = append(c.constants, bytecode.Constant{Type: constType, Value: value})
+	return uint16(len(c.constants) - 1)
+}
+
+// addPath adds an accessor as a path constant, split into its segments so
+// the VM does not have to parse it on every lookup.
+func (c *Compiler) addPath(accessor string) uint16 {
+	return c.addConstant(bytecode.ConstPath, bytecode.ParsePath(accessor))
+}
+
+func (c *Compiler) emit(op bytecode.OpCode, a, b, d uint16) {
 	c.instructions = append(c.instructions, bytecode.PackInstruction(op, a, b, d))
 }
+
+// emitJump emits a jump with a placeholder target and returns its position so
+// it can be patched once the target is known.
+func (c *Compiler) emitJump(op bytecode.OpCode) int {
+	c.emit(op, 0, 0, 0)
+	return len(c.instructions) - 1
+}
+
+// patchJump points the jump at position at to the next instruction to be
+// emitted. Loop starts keep their target in B since A holds the loop path.
+func (c *Compiler) patchJump(at int) {
+	var unpacked bytecode.UnpackedInstruction
+	unpacked.Unpack(c.instructions[at])
+	target := uint16(len(c.instructions))
+	switch unpacked.Op {
+	case bytecode.OpLoopStart, bytecode.OpLoopStartValue:
+		c.instructions[at] = bytecode.PackInstruction(unpacked.Op, unpacked.A, target, unpacked.C)
+	default:
+		c.instructions[at] = bytecode.PackInstruction(unpacked.Op, target, unpacked.B, unpacked.C)
+	}
+}
@@ -3,11 +3,15 @@ package compiler
 import (
 	"testing"
 
+	"github.com/flothq/swap/internal/funcs"
 	"github.com/flothq/swap/internal/lexer"
 	"github.com/flothq/swap/pkg/bytecode"
 )
 
 func TestCompiler(t *testing.T) {
+	upper := function(t, "upper")
+	formatDate := function(t, "formatDate")
+
 	tests := []struct {
 		name     string
 		tokens   []lexer.Token
@@ -37,7 +41,7 @@ func TestCompiler(t *testing.T) {
 			},
 			expected: []bytecode.Instruction{
 				bytecode.PackInstruction(bytecode.OpPrintConst, 0, 0, 0),
-				bytecode.PackInstruction(bytecode.OpResolvePrint, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpResolvePrint, 1, 1, 0),
 				bytecode.PackInstruction(bytecode.OpPrintConst, 2, 0, 0),
 				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
 			},
@@ -63,9 +67,9 @@ func TestCompiler(t *testing.T) {
 			},
 			expected: []bytecode.Instruction{
 				bytecode.PackInstruction(bytecode.OpPrintConst, 0, 0, 0),
-				bytecode.PackInstruction(bytecode.OpLoopStart, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpLoopStart, 1, 5, 1),
 				bytecode.PackInstruction(bytecode.OpPrintConst, 2, 0, 0),
-				bytecode.PackInstruction(bytecode.OpResolvePrint, 3, 0, 0),
+				bytecode.PackInstruction(bytecode.OpResolvePrint, 3, 1, 0),
 				bytecode.PackInstruction(bytecode.OpLoopEnd, 0, 0, 0),
 				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
 			},
@@ -79,7 +83,7 @@ func TestCompiler(t *testing.T) {
 				{Type: lexer.TokenEOF},
 			},
 			expected: []bytecode.Instruction{
-				bytecode.PackInstruction(bytecode.OpResolvePrint, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpResolvePrint, 0, 1, 0),
 				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
 			},
 		},
@@ -95,11 +99,536 @@ func TestCompiler(t *testing.T) {
 				{Type: lexer.TokenEOF},
 			},
 			expected: []bytecode.Instruction{
-				bytecode.PackInstruction(bytecode.OpResolveLoad, 0, 1, 0),
-				bytecode.PackInstruction(bytecode.OpCall, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpCall, upper, 1, 1),
+				bytecode.PackInstruction(bytecode.OpPrint, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "If else if else",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "if"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".a"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLiteralString, Value: "A"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "else"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenIdentifier, Value: "if"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".b"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLiteralString, Value: "B"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "else"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLiteralString, Value: "C"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "end"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpJumpIfFalse, 4, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrintConst, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpJump, 9, 0, 0),
+				bytecode.PackInstruction(bytecode.OpResolvePush, 2, 1, 0),
+				bytecode.PackInstruction(bytecode.OpJumpIfFalse, 8, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrintConst, 3, 0, 0),
+				bytecode.PackInstruction(bytecode.OpJump, 9, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrintConst, 4, 0, 0),
 				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
 			},
 		},
+		{
+			name: "Unclosed if",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "if"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".a"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Else without if",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "else"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "End without block",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "end"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Boolean operators short-circuit",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "if"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".total"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenOperator, Value: ">"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenLiteralNumber, Value: "100"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenOperator, Value: "&&"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenOperator, Value: "!"},
+				{Type: lexer.TokenAccessor, Value: ".vip"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLiteralString, Value: "yes"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "end"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpPushConst, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpGreater, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpJumpIfFalseOrPop, 6, 0, 0),
+				bytecode.PackInstruction(bytecode.OpResolvePush, 2, 1, 0),
+				bytecode.PackInstruction(bytecode.OpNot, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpJumpIfFalse, 8, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrintConst, 3, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Unbalanced parenthesis",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenLParen, Value: "("},
+				{Type: lexer.TokenAccessor, Value: ".a"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Arithmetic precedence",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenAccessor, Value: ".index"},
+				{Type: lexer.TokenOperator, Value: "+"},
+				{Type: lexer.TokenAccessor, Value: ".qty"},
+				{Type: lexer.TokenOperator, Value: "*"},
+				{Type: lexer.TokenOperator, Value: "-"},
+				{Type: lexer.TokenLiteralNumber, Value: "1.5"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpResolvePush, 1, 1, 0),
+				bytecode.PackInstruction(bytecode.OpPushConst, 2, 0, 0),
+				bytecode.PackInstruction(bytecode.OpNegate, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpMultiply, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpAdd, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrint, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Pipeline",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenAccessor, Value: ".created"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenOperator, Value: "|"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenIdentifier, Value: "formatDate"},
+				{Type: lexer.TokenLParen, Value: "("},
+				{Type: lexer.TokenLiteralString, Value: "2006"},
+				{Type: lexer.TokenRParen, Value: ")"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenOperator, Value: "|"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenIdentifier, Value: "upper"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpPushConst, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpCall, formatDate, 1, 2),
+				bytecode.PackInstruction(bytecode.OpCall, upper, 1, 1),
+				bytecode.PackInstruction(bytecode.OpPrint, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Pipeline without function name",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenAccessor, Value: ".name"},
+				{Type: lexer.TokenOperator, Value: "|"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Variable declaration and use",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenVariable, Value: "$u"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenOperator, Value: ":="},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".user"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenVariable, Value: "$u.name"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpStoreVar, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpLoadVar, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpField, 1, 1, 2),
+				bytecode.PackInstruction(bytecode.OpPrint, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Undefined variable",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenVariable, Value: "$missing"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Assignment to undeclared variable",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenVariable, Value: "$x"},
+				{Type: lexer.TokenOperator, Value: "="},
+				{Type: lexer.TokenLiteralNumber, Value: "1"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Range with index and item",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "range"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenVariable, Value: "$i"},
+				{Type: lexer.TokenComma, Value: ","},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenVariable, Value: "$item"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenOperator, Value: ":="},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".items"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenVariable, Value: "$item"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "end"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpLoopStart, 0, 8, 1),
+				bytecode.PackInstruction(bytecode.OpLoopMeta, uint16(bytecode.LoopKey), 0, 0),
+				bytecode.PackInstruction(bytecode.OpStoreVar, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpLoopMeta, uint16(bytecode.LoopValue), 0, 0),
+				bytecode.PackInstruction(bytecode.OpStoreVar, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpLoadVar, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrint, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpLoopEnd, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Range with else",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "range"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".items"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLiteralString, Value: "item"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "else"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLiteralString, Value: "none"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "end"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpLoopStart, 0, 4, 1),
+				bytecode.PackInstruction(bytecode.OpPrintConst, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpLoopEnd, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpJump, 5, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrintConst, 2, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Range with else if",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "range"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".items"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "else"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenIdentifier, Value: "if"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".other"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "end"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Break and continue in range",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "range"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".items"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "continue"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "break"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "end"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpLoopStart, 0, 4, 1),
+				bytecode.PackInstruction(bytecode.OpJump, 3, 0, 0),
+				bytecode.PackInstruction(bytecode.OpLoopBreak, 4, 0, 0),
+				bytecode.PackInstruction(bytecode.OpLoopEnd, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Coalesce chain",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenAccessor, Value: ".a"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenOperator, Value: "??"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".b"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenOperator, Value: "??"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenLiteralString, Value: "x"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 1),
+				bytecode.PackInstruction(bytecode.OpJumpIfNotNilOrPop, 3, 0, 0),
+				bytecode.PackInstruction(bytecode.OpResolvePush, 1, 1, 1),
+				bytecode.PackInstruction(bytecode.OpJumpIfNotNilOrPop, 5, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPushConst, 2, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrint, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Break outside range",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "break"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Index with field path",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenAccessor, Value: ".rows"},
+				{Type: lexer.TokenLBracket, Value: "["},
+				{Type: lexer.TokenLiteralNumber, Value: "0"},
+				{Type: lexer.TokenRBracket, Value: "]"},
+				{Type: lexer.TokenAccessor, Value: ".name"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpPushConst, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpIndex, 2, 1, 0),
+				bytecode.PackInstruction(bytecode.OpField, 3, 1, 4),
+				bytecode.PackInstruction(bytecode.OpPrint, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Unclosed index",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenAccessor, Value: ".rows"},
+				{Type: lexer.TokenLBracket, Value: "["},
+				{Type: lexer.TokenLiteralNumber, Value: "0"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Root path",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenVariable, Value: "$.user.name"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePrint, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "With with else",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "with"},
+				{Type: lexer.TokenSpace, Value: " "},
+				{Type: lexer.TokenAccessor, Value: ".user"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenAccessor, Value: ".name"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "else"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenLiteralString, Value: "none"},
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "end"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpWithStart, 5, 0, 0),
+				bytecode.PackInstruction(bytecode.OpResolvePrint, 1, 1, 0),
+				bytecode.PackInstruction(bytecode.OpWithEnd, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpJump, 6, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrintConst, 2, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Nested function call",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "formatDate"},
+				{Type: lexer.TokenLParen, Value: "("},
+				{Type: lexer.TokenIdentifier, Value: "upper"},
+				{Type: lexer.TokenLParen, Value: "("},
+				{Type: lexer.TokenAccessor, Value: ".date"},
+				{Type: lexer.TokenRParen, Value: ")"},
+				{Type: lexer.TokenComma, Value: ","},
+				{Type: lexer.TokenLiteralString, Value: "2006"},
+				{Type: lexer.TokenRParen, Value: ")"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			expected: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 1, 0),
+				bytecode.PackInstruction(bytecode.OpCall, upper, 1, 1),
+				bytecode.PackInstruction(bytecode.OpPushConst, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpCall, formatDate, 1, 2),
+				bytecode.PackInstruction(bytecode.OpPrint, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+		},
+		{
+			name: "Unknown function",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "shout"},
+				{Type: lexer.TokenLParen, Value: "("},
+				{Type: lexer.TokenAccessor, Value: ".name"},
+				{Type: lexer.TokenRParen, Value: ")"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Wrong number of arguments",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenIdentifier, Value: "upper"},
+				{Type: lexer.TokenLParen, Value: "("},
+				{Type: lexer.TokenAccessor, Value: ".a"},
+				{Type: lexer.TokenComma, Value: ","},
+				{Type: lexer.TokenAccessor, Value: ".b"},
+				{Type: lexer.TokenRParen, Value: ")"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Wrong number of arguments in pipeline",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenAccessor, Value: ".a"},
+				{Type: lexer.TokenOperator, Value: "|"},
+				{Type: lexer.TokenIdentifier, Value: "formatDate"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Loop metadata outside range",
+			tokens: []lexer.Token{
+				{Type: lexer.TokenLDelim, Value: "{{"},
+				{Type: lexer.TokenVariable, Value: "$loop.index"},
+				{Type: lexer.TokenRDelim, Value: "}}"},
+				{Type: lexer.TokenEOF},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -136,3 +665,12 @@ func TestCompiler(t *testing.T) {
 		})
 	}
 }
+
+func function(t *testing.T, name string) uint16 {
+	t.Helper()
+	index, ok := funcs.Default().Lookup(name)
+	if !ok {
+		t.Fatalf("function %s not found", name)
+	}
+	return uint16(index)
+}
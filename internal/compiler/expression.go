@@ -0,0 +1,507 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/flothq/swap/internal/lexer"
+	"github.com/flothq/swap/pkg/bytecode"
+)
+
+var additiveOperators = map[string]bytecode.OpCode{
+	"+": bytecode.OpAdd,
+	"-": bytecode.OpSubtract,
+}
+
+var multiplicativeOperators = map[string]bytecode.OpCode{
+	"*": bytecode.OpMultiply,
+	"/": bytecode.OpDivide,
+	"%": bytecode.OpModulo,
+}
+
+var comparisonOperators = map[string]bytecode.OpCode{
+	"==": bytecode.OpEqual,
+	"!=": bytecode.OpNotEqual,
+	"<":  bytecode.OpLess,
+	"<=": bytecode.OpLessEqual,
+	">":  bytecode.OpGreater,
+	">=": bytecode.OpGreaterEqual,
+}
+
+// compilePipeline compiles an expression followed by any number of "| fn" or
+// "| fn(args)" stages, leaving the final value on the VM stack. Each stage
+// receives the value of the previous one as its first argument, so
+// `.created | formatDate("2006")` is formatDate(.created, "2006"): the
+// previous value is already on the stack below the stage's own arguments.
+func (c *Compiler) compilePipeline() error {
+	start := len(c.instructions)
+	if err := c.compileExpression(); err != nil {
+		return err
+	}
+
+	for stage := 0; c.matchOperator("|"); stage++ {
+		c.eatWhitespace()
+		token := c.current()
+		if token.Type != lexer.TokenIdentifier {
+			return fmt.Errorf("expected function name after '|', got %v", token)
+		}
+		fn, err := c.lookupFunction(token.Value)
+		if err != nil {
+			return err
+		}
+		line := c.line()
+		c.pos++
+		if stage == 0 {
+			c.markOptional(start, token.Value)
+		}
+
+		count := 1
+		if c.current().Type == lexer.TokenLParen {
+			n, err := c.compileArguments("")
+			if err != nil {
+				return err
+			}
+			count += n
+		}
+		if err := c.emitCall(fn, line, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileExpression emits the instructions that leave the value of the
+// expression at the current position on the VM stack. Operators bind, from
+// loosest to tightest: ??, ||, &&, comparisons, + and -, * / and %, then
+// unary ! and -.
+func (c *Compiler) compileExpression() error {
+	return c.compileCoalesce()
+}
+
+// compileCoalesce compiles a ?? b, which is a unless a is nil, as it is for a
+// missing key, and b otherwise. b is only evaluated when it is needed.
+func (c *Compiler) compileCoalesce() error {
+	start := len(c.instructions)
+	if err := c.compileOr(); err != nil {
+		return err
+	}
+	for c.matchOperator("??") {
+		c.markOptional(start, "??")
+		jump := c.emitJump(bytecode.OpJumpIfNotNilOrPop)
+		start = len(c.instructions)
+		if err := c.compileOr(); err != nil {
+			return err
+		}
+		c.patchJump(jump)
+	}
+	return nil
+}
+
+// markOptional exempts the operand compiled from start from the missing-key
+// policy when the operator or function consuming it is ?? or default, which
+// exist to handle missing keys, and the operand ends in a lookup. Every path,
+// field and index lookup in it is marked: OpResolvePush and OpIndex get a C
+// of 1 and OpField loses the name it would report in C.
+func (c *Compiler) markOptional(start int, consumer string) {
+	if consumer != "??" && consumer != "default" || start >= len(c.instructions) {
+		return
+	}
+	var unpacked bytecode.UnpackedInstruction
+	unpacked.Unpack(c.instructions[len(c.instructions)-1])
+	switch unpacked.Op {
+	case bytecode.OpResolvePush, bytecode.OpField, bytecode.OpIndex:
+	default:
+		return
+	}
+	for i := start; i < len(c.instructions); i++ {
+		unpacked.Unpack(c.instructions[i])
+		switch unpacked.Op {
+		case bytecode.OpResolvePush, bytecode.OpIndex:
+			c.instructions[i] = bytecode.PackInstruction(unpacked.Op, unpacked.A, unpacked.B, 1)
+		case bytecode.OpField:
+			c.instructions[i] = bytecode.PackInstruction(unpacked.Op, unpacked.A, unpacked.B, 0)
+		}
+	}
+}
+
+// compileOr and compileAnd short-circuit: like text/template they evaluate to
+// the first operand that decides the result rather than to a plain bool.
+func (c *Compiler) compileOr() error {
+	if err := c.compileAnd(); err != nil {
+		return err
+	}
+	for c.matchOperator("||") {
+		jump := c.emitJump(bytecode.OpJumpIfTrueOrPop)
+		if err := c.compileAnd(); err != nil {
+			return err
+		}
+		c.patchJump(jump)
+	}
+	return nil
+}
+
+func (c *Compiler) compileAnd() error {
+	if err := c.compileComparison(); err != nil {
+		return err
+	}
+	for c.matchOperator("&&") {
+		jump := c.emitJump(bytecode.OpJumpIfFalseOrPop)
+		if err := c.compileComparison(); err != nil {
+			return err
+		}
+		c.patchJump(jump)
+	}
+	return nil
+}
+
+func (c *Compiler) compileComparison() error {
+	if err := c.compileAdditive(); err != nil {
+		return err
+	}
+	if op, ok := c.matchBinary(comparisonOperators); ok {
+		if err := c.compileAdditive(); err != nil {
+			return err
+		}
+		c.emit(op, 0, 0, 0)
+	}
+	return nil
+}
+
+func (c *Compiler) compileAdditive() error {
+	if err := c.compileMultiplicative(); err != nil {
+		return err
+	}
+	for {
+		op, ok := c.matchBinary(additiveOperators)
+		if !ok {
+			return nil
+		}
+		if err := c.compileMultiplicative(); err != nil {
+			return err
+		}
+		c.emit(op, 0, 0, 0)
+	}
+}
+
+func (c *Compiler) compileMultiplicative() error {
+	if err := c.compileUnary(); err != nil {
+		return err
+	}
+	for {
+		op, ok := c.matchBinary(multiplicativeOperators)
+		if !ok {
+			return nil
+		}
+		if err := c.compileUnary(); err != nil {
+			return err
+		}
+		c.emit(op, 0, 0, 0)
+	}
+}
+
+func (c *Compiler) compileUnary() error {
+	if c.matchOperator("!") {
+		if err := c.compileUnary(); err != nil {
+			return err
+		}
+		c.emit(bytecode.OpNot, 0, 0, 0)
+		return nil
+	}
+	if c.matchOperator("-") {
+		if err := c.compileUnary(); err != nil {
+			return err
+		}
+		c.emit(bytecode.OpNegate, 0, 0, 0)
+		return nil
+	}
+	return c.compileOperand()
+}
+
+func (c *Compiler) compileOperand() error {
+	start := c.pos
+	if err := c.compilePrimary(); err != nil {
+		return err
+	}
+	return c.compileSubscripts(start)
+}
+
+// compileSubscripts compiles the index expressions written directly after an
+// operand starting at token start, as in .items[0], .headers["content-type"]
+// or .rows[$i].name. A field path right after the closing bracket is looked
+// up on the result. Each lookup carries its line and its text from start,
+// which the VM reports for a missing key.
+func (c *Compiler) compileSubscripts(start int) error {
+	for c.current().Type == lexer.TokenLBracket {
+		c.pos++
+		if err := c.compileExpression(); err != nil {
+			return err
+		}
+		c.eatWhitespace()
+		if c.current().Type != lexer.TokenRBracket {
+			return fmt.Errorf("expected ']', got %v", c.current())
+		}
+		c.pos++
+		c.emit(bytecode.OpIndex, c.addSource(start), c.line(), 0)
+
+		if token := c.current(); token.Type == lexer.TokenAccessor && token.Value != "." {
+			line := c.line()
+			c.pos++
+			c.emit(bytecode.OpField, c.addPath(token.Value), line, c.addSource(start))
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) compilePrimary() error {
+	c.eatWhitespace()
+	token := c.current()
+	switch token.Type {
+	case lexer.TokenLParen:
+		c.pos++
+		if err := c.compileExpression(); err != nil {
+			return err
+		}
+		c.eatWhitespace()
+		if c.current().Type != lexer.TokenRParen {
+			return fmt.Errorf("expected ')', got %v", c.current())
+		}
+	case lexer.TokenAccessor:
+		c.emit(bytecode.OpResolvePush, c.addPath(token.Value), c.line(), 0)
+	case lexer.TokenVariable:
+		return c.compileVariable()
+	case lexer.TokenIdentifier:
+		if c.isFunctionCall() {
+			return c.compileFunctionCall()
+		}
+		if c.isBareCall() {
+			fn, err := c.lookupFunction(token.Value)
+			if err != nil {
+				return err
+			}
+			if err := c.emitCall(fn, c.line(), 0); err != nil {
+				return err
+			}
+			break
+		}
+		c.emit(bytecode.OpResolvePush, c.addPath(token.Value), c.line(), 0)
+	case lexer.TokenLiteralString:
+		c.emit(bytecode.OpPushConst, c.addConstant(bytecode.ConstString, token.Value), 0, 0)
+	case lexer.TokenLiteralBoolean:
+		c.emit(bytecode.OpPushConst, c.addConstant(bytecode.ConstBoolean, token.Value == "true"), 0, 0)
+	case lexer.TokenLiteralNil:
+		c.emit(bytecode.OpPushConst, c.addConstant(bytecode.ConstNil, nil), 0, 0)
+	case lexer.TokenLiteralNumber:
+		if strings.Contains(token.Value, ".") {
+			f, err := strconv.ParseFloat(token.Value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid number %q: %w", token.Value, err)
+			}
+			c.emit(bytecode.OpPushConst, c.addConstant(bytecode.ConstFloat, f), 0, 0)
+			break
+		}
+		n, err := strconv.ParseInt(token.Value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q: %w", token.Value, err)
+		}
+		c.emit(bytecode.OpPushConst, c.addConstant(bytecode.ConstInteger, n), 0, 0)
+	default:
+		return fmt.Errorf("unexpected token in expression: %v", token)
+	}
+	c.pos++
+	return nil
+}
+
+// compileVariable pushes the value of $name, followed by a field lookup when
+// the variable is written as $name.field. $ on its own is the root context,
+// so $.path resolves against the root whatever the dot is.
+func (c *Compiler) compileVariable() error {
+	token := c.current()
+	if root, ok := rootPath(token.Value); ok {
+		c.emit(bytecode.OpResolvePush, c.addConstant(bytecode.ConstPath, root), c.line(), 0)
+		c.pos++
+		return nil
+	}
+	name, path, _ := strings.Cut(token.Value, ".")
+	slot, ok := c.lookupVariable(name)
+	if !ok && name == "$loop" {
+		return c.compileLoopMeta(path)
+	}
+	if !ok {
+		return fmt.Errorf("undefined variable %s", name)
+	}
+	c.emit(bytecode.OpLoadVar, slot, 0, 0)
+	if path != "" {
+		c.emit(bytecode.OpField, c.addPath("."+path), c.line(), c.addConstant(bytecode.ConstString, token.Value))
+	}
+	c.pos++
+	return nil
+}
+
+// rootPath returns the path of a variable written as $ or $.path, which is
+// looked up on the root context, and false for any other variable.
+func rootPath(variable string) (bytecode.Path, bool) {
+	name, path, _ := strings.Cut(variable, ".")
+	if name != "$" {
+		return bytecode.Path{}, false
+	}
+	root := bytecode.Path{Root: true}
+	if path != "" {
+		root.Segments = strings.Split(path, ".")
+	}
+	return root, true
+}
+
+// compileLoopMeta compiles $loop.index, $loop.first and the other fields
+// describing the innermost range loop.
+func (c *Compiler) compileLoopMeta(field string) error {
+	if !c.inRange() {
+		return fmt.Errorf("$loop used outside of a range block")
+	}
+	meta, ok := bytecode.LoopFields[field]
+	if !ok {
+		return fmt.Errorf("unknown loop field $loop.%s", field)
+	}
+	c.emit(bytecode.OpLoopMeta, uint16(meta), 0, 0)
+	c.pos++
+	return nil
+}
+
+// addSource adds the template text of the tokens from start up to the
+// current position as a string constant, naming the expression they form.
+// Spaces are dropped and string literals quoted again.
+func (c *Compiler) addSource(start int) uint16 {
+	var sb strings.Builder
+	for _, token := range c.tokens[start:c.pos] {
+		switch token.Type {
+		case lexer.TokenSpace:
+		case lexer.TokenLiteralString:
+			sb.WriteString(strconv.Quote(token.Value))
+		default:
+			sb.WriteString(token.Value)
+		}
+	}
+	return c.addConstant(bytecode.ConstString, sb.String())
+}
+
+// isBareCall reports whether the current token is the bare name of a
+// function without parameters, such as now, which is called rather than
+// looked up in the context. Other bare names are context keys.
+func (c *Compiler) isBareCall() bool {
+	token := c.current()
+	if token.Type != lexer.TokenIdentifier {
+		return false
+	}
+	index, ok := c.funcs.Lookup(token.Value)
+	return ok && c.funcs.TakesNoArguments(index)
+}
+
+func (c *Compiler) isFunctionCall() bool {
+	return c.current().Type == lexer.TokenIdentifier && c.pos+1 < len(c.tokens) && c.tokens[c.pos+1].Type == lexer.TokenLParen
+}
+
+// compileFunctionCall compiles fn(args), leaving the result on the stack.
+func (c *Compiler) compileFunctionCall() error {
+	token := c.current()
+	if token.Type != lexer.TokenIdentifier {
+		return fmt.Errorf("expected function name, got %v", token)
+	}
+	fn, err := c.lookupFunction(token.Value)
+	if err != nil {
+		return err
+	}
+	line := c.line()
+	c.pos++
+
+	count, err := c.compileArguments(token.Value)
+	if err != nil {
+		return err
+	}
+	return c.emitCall(fn, line, count)
+}
+
+// emitCall calls fn with the top count values on the stack, the last argument
+// being on top, which the call replaces with its result. line is the template
+// line of the call, which the VM reports if the function fails.
+func (c *Compiler) emitCall(fn, line uint16, count int) error {
+	if err := c.funcs.CheckArity(int(fn), count); err != nil {
+		return err
+	}
+	if count > math.MaxUint16 {
+		return fmt.Errorf("too many arguments in call to %s: %d", c.funcs.Name(int(fn)), count)
+	}
+	c.emit(bytecode.OpCall, fn, line, uint16(count))
+	return nil
+}
+
+// lookupFunction resolves a function name to its index in the function
+// table, which becomes the A operand of OpCall.
+func (c *Compiler) lookupFunction(name string) (uint16, error) {
+	index, ok := c.funcs.Lookup(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown function %s", name)
+	}
+	return uint16(index), nil
+}
+
+// compileArguments compiles a parenthesised argument list, pushing each
+// argument on the stack, and returns the number of arguments. name is the
+// function the first argument is passed to, or empty when the arguments
+// follow a piped value.
+func (c *Compiler) compileArguments(name string) (int, error) {
+	if c.current().Type != lexer.TokenLParen {
+		return 0, fmt.Errorf("expected '(' after function name, got %v", c.current())
+	}
+	c.pos++
+
+	count := 0
+	for {
+		c.eatWhitespace()
+		if c.current().Type == lexer.TokenRParen {
+			c.pos++
+			return count, nil
+		}
+		if count > 0 {
+			if c.current().Type != lexer.TokenComma {
+				return 0, fmt.Errorf("unexpected token in function call: %v", c.current())
+			}
+			c.pos++
+			c.eatWhitespace()
+		}
+		start := len(c.instructions)
+		if err := c.compileExpression(); err != nil {
+			return 0, err
+		}
+		if count == 0 {
+			c.markOptional(start, name)
+		}
+		count++
+	}
+}
+
+// matchBinary consumes the next token if it is one of the given operators
+// and returns its opcode.
+func (c *Compiler) matchBinary(operators map[string]bytecode.OpCode) (bytecode.OpCode, bool) {
+	c.eatWhitespace()
+	token := c.current()
+	if token.Type != lexer.TokenOperator {
+		return 0, false
+	}
+	op, ok := operators[token.Value]
+	if ok {
+		c.pos++
+	}
+	return op, ok
+}
+
+// matchOperator consumes the operator op if it is the next token.
+func (c *Compiler) matchOperator(op string) bool {
+	c.eatWhitespace()
+	token := c.current()
+	if token.Type == lexer.TokenOperator && token.Value == op {
+		c.pos++
+		return true
+	}
+	return false
+}
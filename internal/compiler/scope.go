@@ -0,0 +1,40 @@
+package compiler
+
+// scope maps the variables declared in a block to their VM slots. Slots are
+// handed out in declaration order and reused once the block is closed.
+type scope struct {
+	vars map[string]uint16
+	base uint16
+}
+
+func (c *Compiler) pushScope() {
+	c.scopes = append(c.scopes, scope{base: c.nextSlot})
+}
+
+func (c *Compiler) popScope() {
+	c.nextSlot = c.scopes[len(c.scopes)-1].base
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+// declareVariable allocates a slot for name in the innermost scope. A
+// redeclaration gets a fresh slot so it shadows rather than overwrites.
+func (c *Compiler) declareVariable(name string) uint16 {
+	s := &c.scopes[len(c.scopes)-1]
+	if s.vars == nil {
+		s.vars = make(map[string]uint16)
+	}
+	slot := c.nextSlot
+	c.nextSlot++
+	s.vars[name] = slot
+	return slot
+}
+
+// lookupVariable finds the slot of the innermost variable called name.
+func (c *Compiler) lookupVariable(name string) (uint16, bool) {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if slot, ok := c.scopes[i].vars[name]; ok {
+			return slot, true
+		}
+	}
+	return 0, false
+}
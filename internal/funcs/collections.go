@@ -0,0 +1,362 @@
+package funcs
+
+import (
+	"fmt"
+	"iter"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/flothq/swap/internal/access"
+	"github.com/flothq/swap/internal/values"
+)
+
+// collectionFuncs work on everything a range loop iterates: slices, arrays,
+// maps (by their values, in the key order of range), receive channels and
+// iter.Seq and iter.Seq2 iterators. nil is an empty collection. Channels and
+// iterators are read to the end.
+var collectionFuncs = map[string]any{
+	"len":     length,
+	"first":   first,
+	"last":    last,
+	"join":    join,
+	"sort":    sortItems,
+	"reverse": reverse,
+	"slice":   slice,
+	"sortBy":  sortBy,
+	"groupBy": groupBy,
+}
+
+// items returns the elements of a collection. The result may be the
+// collection itself, so callers must copy it before changing it.
+func items(list interface{}) ([]interface{}, error) {
+	switch v := list.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return v, nil
+	case []string:
+		out := make([]interface{}, len(v))
+		for i, s := range v {
+			out[i] = s
+		}
+		return out, nil
+	case iter.Seq[interface{}]:
+		return slices.Collect(v), nil
+	case iter.Seq2[interface{}, interface{}]:
+		return collectSeq2(v), nil
+	}
+
+	rv := reflect.ValueOf(list)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			out[i] = rv.Index(i).Interface()
+		}
+		return out, nil
+	case reflect.Map:
+		keys := access.MapKeys(rv)
+		out := make([]interface{}, len(keys))
+		for i, k := range keys {
+			out[i] = rv.MapIndex(k).Interface()
+		}
+		return out, nil
+	case reflect.Chan:
+		if rv.Type().ChanDir()&reflect.RecvDir == 0 {
+			return nil, fmt.Errorf("cannot iterate send-only channel %s", rv.Type())
+		}
+		var out []interface{}
+		for {
+			item, ok := rv.Recv()
+			if !ok {
+				return out, nil
+			}
+			out = append(out, item.Interface())
+		}
+	case reflect.Func:
+		if seq, ok := access.Seq(rv); ok {
+			return collectSeq2(seq), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot iterate %T", list)
+}
+
+func collectSeq2(seq iter.Seq2[interface{}, interface{}]) []interface{} {
+	var out []interface{}
+	for _, v := range seq {
+		out = append(out, v)
+	}
+	return out
+}
+
+// length returns the number of elements of a collection, or the number of
+// characters of a string.
+func length(v interface{}) (int, error) {
+	if s, ok := v.(string); ok {
+		return utf8.RuneCountInString(s), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len(), nil
+	}
+	list, err := items(v)
+	return len(list), err
+}
+
+// first returns the first element of a collection, or nil when it is empty.
+// With a count, it returns up to that many elements from the start instead.
+func first(list interface{}, count ...int) (interface{}, error) {
+	all, err := items(list)
+	if err != nil {
+		return nil, err
+	}
+	n, err := optionalCount(count)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		if len(all) == 0 {
+			return nil, nil
+		}
+		return all[0], nil
+	}
+	return slices.Clone(all[:min(n, len(all))]), nil
+}
+
+// last returns the last element of a collection, or nil when it is empty.
+// With a count, it returns up to that many elements from the end instead.
+func last(list interface{}, count ...int) (interface{}, error) {
+	all, err := items(list)
+	if err != nil {
+		return nil, err
+	}
+	n, err := optionalCount(count)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		if len(all) == 0 {
+			return nil, nil
+		}
+		return all[len(all)-1], nil
+	}
+	return slices.Clone(all[len(all)-min(n, len(all)):]), nil
+}
+
+// optionalCount returns the count of first and last, or -1 when there is
+// none.
+func optionalCount(count []int) (int, error) {
+	switch {
+	case len(count) == 0:
+		return -1, nil
+	case len(count) > 1:
+		return 0, fmt.Errorf("expected at most one count, got %d", len(count))
+	case count[0] < 0:
+		return 0, fmt.Errorf("negative count %d", count[0])
+	default:
+		return count[0], nil
+	}
+}
+
+// join formats the elements of a collection and joins them with sep.
+func join(list interface{}, sep string) (string, error) {
+	if list, ok := list.([]string); ok {
+		return strings.Join(list, sep), nil
+	}
+	all, err := items(list)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i, item := range all {
+		if i > 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteString(values.String(item))
+	}
+	return sb.String(), nil
+}
+
+// sortItems returns the elements of a collection in ascending order. They
+// must all be strings, all numbers, all booleans or all times.
+func sortItems(list interface{}) ([]interface{}, error) {
+	all, err := items(list)
+	if err != nil {
+		return nil, err
+	}
+	return sortedBy(all, func(item interface{}) interface{} { return item })
+}
+
+// sortBy sorts the elements of a collection by the value at path, such as
+// "price" or ".customer.name", keeping the order of equal elements. Elements
+// where the path is missing come last.
+func sortBy(list interface{}, path string) ([]interface{}, error) {
+	all, err := items(list)
+	if err != nil {
+		return nil, err
+	}
+	segments := pathSegments(path)
+	return sortedBy(all, func(item interface{}) interface{} {
+		return access.Path(item, segments)
+	})
+}
+
+func sortedBy(all []interface{}, key func(interface{}) interface{}) ([]interface{}, error) {
+	keys := make([]interface{}, len(all))
+	order := make([]int, len(all))
+	for i, item := range all {
+		keys[i] = key(item)
+		order[i] = i
+	}
+
+	var err error
+	slices.SortStableFunc(order, func(a, b int) int {
+		ka, kb := keys[a], keys[b]
+		switch {
+		case ka == nil && kb == nil:
+			return 0
+		case ka == nil:
+			return 1
+		case kb == nil:
+			return -1
+		}
+		c, cmpErr := compareItems(ka, kb)
+		if cmpErr != nil && err == nil {
+			err = cmpErr
+		}
+		return c
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]interface{}, len(all))
+	for i, j := range order {
+		out[i] = all[j]
+	}
+	return out, nil
+}
+
+// compareItems orders two strings, two numbers, two booleans or two times.
+func compareItems(a, b interface{}) (int, error) {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), nil
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, nil
+			case b:
+				return -1, nil
+			default:
+				return 1, nil
+			}
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b), nil
+		}
+	default:
+		na, errA := toNumber(a)
+		nb, errB := toNumber(b)
+		if _, isString := b.(string); errA == nil && errB == nil && !isString {
+			return values.CompareNumbers(na, nb), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T and %T", a, b)
+}
+
+// reverse returns the elements of a collection in reverse order.
+func reverse(list interface{}) ([]interface{}, error) {
+	all, err := items(list)
+	if err != nil {
+		return nil, err
+	}
+	out := slices.Clone(all)
+	slices.Reverse(out)
+	return out, nil
+}
+
+// slice returns the elements of a collection from start up to, but not
+// including, end, which defaults to the length. Negative positions count from
+// the end and positions past either end are clamped, so slice(.items, 0, 3)
+// is at most three elements.
+func slice(list interface{}, start int, end ...int) ([]interface{}, error) {
+	all, err := items(list)
+	if err != nil {
+		return nil, err
+	}
+	stop := len(all)
+	switch len(end) {
+	case 0:
+	case 1:
+		stop = end[0]
+	default:
+		return nil, fmt.Errorf("expected at most one end position, got %d", len(end))
+	}
+	start, stop = clampPosition(start, len(all)), clampPosition(stop, len(all))
+	if start >= stop {
+		return []interface{}{}, nil
+	}
+	return slices.Clone(all[start:stop]), nil
+}
+
+func clampPosition(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	return max(0, min(i, n))
+}
+
+// groupBy groups the elements of a collection by the value at path. The
+// groups come in the order their keys first appear; each is a map with the
+// key under "key" and the elements under "items", so a template can write
+// {{ range groupBy(.orders, "status") }}{{ .key }}: {{ len(.items) }}{{ end }}.
+func groupBy(list interface{}, path string) ([]map[string]interface{}, error) {
+	all, err := items(list)
+	if err != nil {
+		return nil, err
+	}
+	segments := pathSegments(path)
+
+	groups := []map[string]interface{}{}
+	index := make(map[interface{}]int)
+	for _, item := range all {
+		key := access.Path(item, segments)
+		id := key
+		if key != nil && !reflect.ValueOf(key).Comparable() {
+			id = fmt.Sprint(key)
+		}
+		i, ok := index[id]
+		if !ok {
+			i = len(groups)
+			index[id] = i
+			groups = append(groups, map[string]interface{}{"key": key, "items": []interface{}{}})
+		}
+		groups[i]["items"] = append(groups[i]["items"].([]interface{}), item)
+	}
+	return groups, nil
+}
+
+// pathSegments splits a path such as ".customer.name" into its segments.
+func pathSegments(path string) []string {
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
@@ -0,0 +1,118 @@
+package funcs
+
+import (
+	"iter"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	Name  string
+	Price float64 `swap:"price"`
+	Tags  []string
+}
+
+func testChannel(values ...interface{}) <-chan interface{} {
+	ch := make(chan interface{}, len(values))
+	for _, v := range values {
+		ch <- v
+	}
+	close(ch)
+	return ch
+}
+
+func TestCollectionFuncs(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"name": "b", "status": "open", "n": 2},
+		{"name": "a", "status": "closed", "n": 3},
+		{"name": "c", "status": "open"},
+		{"name": "d", "status": "closed", "n": 1},
+	}
+	products := []testItem{{Name: "pen", Price: 2.5}, {Name: "book", Price: 12}, {Name: "cup", Price: 4}}
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []funcCase{
+		{name: "len slice", fn: "len", args: []interface{}{[]int{1, 2, 3}}, expected: 3},
+		{name: "len map", fn: "len", args: []interface{}{map[string]int{"a": 1}}, expected: 1},
+		{name: "len string", fn: "len", args: []interface{}{"héllo"}, expected: 5},
+		{name: "len nil", fn: "len", args: []interface{}{nil}, expected: 0},
+		{name: "len channel", fn: "len", args: []interface{}{testChannel(1, 2)}, expected: 2},
+		{name: "len sequence", fn: "len", args: []interface{}{slices.Values([]int{4, 5, 6})}, expected: 3},
+		{name: "len pointer to slice", fn: "len", args: []interface{}{&[]string{"a"}}, expected: 1},
+		{name: "len number", fn: "len", args: []interface{}{3}, wantErr: true},
+		{name: "first", fn: "first", args: []interface{}{[]string{"a", "b"}}, expected: "a"},
+		{name: "first empty", fn: "first", args: []interface{}{[]string{}}, expected: nil},
+		{name: "first count", fn: "first", args: []interface{}{[]int{1, 2, 3, 4}, 3}, expected: []interface{}{1, 2, 3}},
+		{name: "first count beyond length", fn: "first", args: []interface{}{[]int{1}, 3}, expected: []interface{}{1}},
+		{name: "first negative count", fn: "first", args: []interface{}{[]int{1}, -1}, wantErr: true},
+		{name: "first map in key order", fn: "first", args: []interface{}{map[string]int{"b": 2, "a": 1}}, expected: 1},
+		{name: "last", fn: "last", args: []interface{}{[3]int{1, 2, 3}}, expected: 3},
+		{name: "last count", fn: "last", args: []interface{}{[]int{1, 2, 3}, 2}, expected: []interface{}{2, 3}},
+		{name: "last nil", fn: "last", args: []interface{}{nil}, expected: nil},
+		{name: "join strings", fn: "join", args: []interface{}{[]string{"a", "b"}, ", "}, expected: "a, b"},
+		{name: "join any slice", fn: "join", args: []interface{}{[]interface{}{"a", 1, 2.5, true}, "/"}, expected: "a/1/2.5/true"},
+		{name: "join array", fn: "join", args: []interface{}{[2]int{1, 2}, "-"}, expected: "1-2"},
+		{name: "join map values", fn: "join", args: []interface{}{map[int]string{2: "b", 10: "c", 1: "a"}, ""}, expected: "abc"},
+		{name: "join channel", fn: "join", args: []interface{}{testChannel("x", "y"), "+"}, expected: "x+y"},
+		{name: "join nil", fn: "join", args: []interface{}{nil, ","}, expected: ""},
+		{name: "join nil elements", fn: "join", args: []interface{}{[]interface{}{"a", nil, 3, (*string)(nil)}, ","}, expected: "a,,3,"},
+		{name: "join string", fn: "join", args: []interface{}{"abc", ","}, wantErr: true},
+		{name: "sort strings", fn: "sort", args: []interface{}{[]string{"b", "c", "a"}}, expected: []interface{}{"a", "b", "c"}},
+		{name: "sort mixed numbers", fn: "sort", args: []interface{}{[]interface{}{3, 1.5, int64(2)}}, expected: []interface{}{1.5, int64(2), 3}},
+		{name: "sort times", fn: "sort", args: []interface{}{[]time.Time{day.Add(time.Hour), day}}, expected: []interface{}{day, day.Add(time.Hour)}},
+		{name: "sort incomparable", fn: "sort", args: []interface{}{[]interface{}{1, "a"}}, wantErr: true},
+		{name: "sort numeric strings as strings", fn: "sort", args: []interface{}{[]string{"10", "9"}}, expected: []interface{}{"10", "9"}},
+		{name: "reverse", fn: "reverse", args: []interface{}{[]int{1, 2, 3}}, expected: []interface{}{3, 2, 1}},
+		{name: "reverse sequence", fn: "reverse", args: []interface{}{iter.Seq[interface{}](slices.Values([]interface{}{"a", "b"}))}, expected: []interface{}{"b", "a"}},
+		{name: "slice", fn: "slice", args: []interface{}{[]int{1, 2, 3, 4}, 1, 3}, expected: []interface{}{2, 3}},
+		{name: "slice to end", fn: "slice", args: []interface{}{[]int{1, 2, 3}, 1}, expected: []interface{}{2, 3}},
+		{name: "slice clamps", fn: "slice", args: []interface{}{[]int{1, 2}, 0, 10}, expected: []interface{}{1, 2}},
+		{name: "slice negative", fn: "slice", args: []interface{}{[]int{1, 2, 3}, -2}, expected: []interface{}{2, 3}},
+		{name: "slice empty", fn: "slice", args: []interface{}{[]int{1, 2, 3}, 2, 1}, expected: []interface{}{}},
+		{name: "slice two ends", fn: "slice", args: []interface{}{[]int{1}, 0, 1, 2}, wantErr: true},
+		{name: "sortBy map field", fn: "sortBy", args: []interface{}{rows, "n"}, expected: []interface{}{rows[3], rows[0], rows[1], rows[2]}},
+		{name: "sortBy string field", fn: "sortBy", args: []interface{}{rows, ".name"}, expected: []interface{}{rows[1], rows[0], rows[2], rows[3]}},
+		{name: "sortBy is stable", fn: "sortBy", args: []interface{}{rows, "status"}, expected: []interface{}{rows[1], rows[3], rows[0], rows[2]}},
+		{name: "sortBy struct tag", fn: "sortBy", args: []interface{}{products, "price"}, expected: []interface{}{products[0], products[2], products[1]}},
+		{name: "sortBy struct pointers", fn: "sortBy", args: []interface{}{[]*testItem{&products[1], &products[0]}, "Name"}, expected: []interface{}{&products[1], &products[0]}},
+		{name: "sortBy incomparable", fn: "sortBy", args: []interface{}{products, "Tags"}, wantErr: true},
+		{name: "sortBy mixed types", fn: "sortBy", args: []interface{}{[]interface{}{map[string]interface{}{"a": 1}, map[string]interface{}{"a": "x"}}, "a"}, wantErr: true},
+	}
+
+	runFuncCases(t, Default(), tests)
+}
+
+func TestGroupBy(t *testing.T) {
+	orders := []map[string]interface{}{
+		{"id": 1, "status": "open"},
+		{"id": 2, "status": "paid"},
+		{"id": 3, "status": "open"},
+		{"id": 4},
+	}
+	got, err := groupBy(orders, "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{"key": "open", "items": []interface{}{orders[0], orders[2]}},
+		{"key": "paid", "items": []interface{}{orders[1]}},
+		{"key": nil, "items": []interface{}{orders[3]}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("groupBy() = %v, want %v", got, expected)
+	}
+
+	got, err = groupBy([]testItem{{Tags: []string{"a"}}, {Tags: []string{"a"}}, {Tags: nil}}, "Tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || len(got[0]["items"].([]interface{})) != 2 {
+		t.Errorf("groupBy() by slice = %v, want two groups", got)
+	}
+
+	if _, err := groupBy(42, "a"); err == nil {
+		t.Error("groupBy(42) expected an error")
+	}
+}
@@ -0,0 +1,233 @@
+package funcs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	// Zone names resolve without the system time zone database.
+	_ "time/tzdata"
+)
+
+// dateFuncs returns the date functions, with now and relative reading the
+// current time from clock. now drops the monotonic clock reading, which would
+// otherwise show when the time is printed. Every function that takes a date
+// accepts a time.Time, a *time.Time, Unix seconds or a string in one of
+// inputLayouts.
+func dateFuncs(clock func() time.Time) map[string]any {
+	now := func() time.Time {
+		return clock().Round(0)
+	}
+	return map[string]any{
+		"formatDate":  formatDate,
+		"parseDate":   parseDate,
+		"now":         now,
+		"inZone":      inZone,
+		"addDuration": addDuration,
+		"addDate":     addDate,
+		"unix":        unix,
+		"relative": func(date interface{}) (string, error) {
+			t, err := toTime(date)
+			if err != nil {
+				return "", err
+			}
+			return relative(t, clock()), nil
+		},
+	}
+}
+
+// inputLayouts are the layouts date strings are parsed with, in order.
+// Layouts without a zone are read as UTC.
+var inputLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	time.DateTime,
+	"2006-01-02 15:04",
+	time.DateOnly,
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.RFC822Z,
+	time.RFC822,
+	time.ANSIC,
+}
+
+// toTime converts a date argument to a time.Time.
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		if v == nil {
+			return time.Time{}, fmt.Errorf("nil time")
+		}
+		return *v, nil
+	case int:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	case string:
+		s := strings.TrimSpace(v)
+		for _, layout := range inputLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("cannot parse %q as a date", v)
+	default:
+		return time.Time{}, fmt.Errorf("cannot use %T as a date", value)
+	}
+}
+
+// formatDate formats a date with a Go layout such as "2006-01-02".
+func formatDate(date interface{}, layout string) (string, error) {
+	t, err := toTime(date)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+// parseDate parses s with the given layout, or with the input layouts when
+// none is given.
+func parseDate(s string, layout ...string) (time.Time, error) {
+	switch len(layout) {
+	case 0:
+		return toTime(s)
+	case 1:
+		return time.Parse(layout[0], strings.TrimSpace(s))
+	default:
+		return time.Time{}, fmt.Errorf("expected at most one layout, got %d", len(layout))
+	}
+}
+
+var locations sync.Map // zone name -> *time.Location
+
+// inZone converts a date to the IANA time zone name, such as
+// "Europe/Paris", "UTC" or "Local".
+func inZone(date interface{}, name string) (time.Time, error) {
+	t, err := toTime(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if loc, ok := locations.Load(name); ok {
+		return t.In(loc.(*time.Location)), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	locations.Store(name, loc)
+	return t.In(loc), nil
+}
+
+// addDuration adds a duration to a date. The duration is a time.Duration, a
+// number of seconds or a string such as "90m", "-1h30m" or "2d12h"; a d unit
+// counts 24 hours.
+func addDuration(date interface{}, duration interface{}) (time.Time, error) {
+	t, err := toTime(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	d, err := toDuration(duration)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(d), nil
+}
+
+func toDuration(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, nil
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	case string:
+		return parseDuration(v)
+	default:
+		return 0, fmt.Errorf("cannot use %T as a duration", value)
+	}
+}
+
+// parseDuration is time.ParseDuration with a leading number of days.
+func parseDuration(s string) (time.Duration, error) {
+	rest, sign := strings.TrimSpace(s), time.Duration(1)
+	if strings.HasPrefix(rest, "-") {
+		rest, sign = rest[1:], -1
+	}
+	var days time.Duration
+	if i := strings.IndexByte(rest, 'd'); i >= 0 {
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil || n > math.MaxInt64/int64(24*time.Hour) {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		days, rest = time.Duration(n)*24*time.Hour, rest[i+1:]
+	}
+	if rest == "" {
+		return sign * days, nil
+	}
+	d, err := time.ParseDuration(rest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return sign * (days + d), nil
+}
+
+// addDate adds years, months and days to a date like time.Time.AddDate.
+func addDate(date interface{}, years, months, days int) (time.Time, error) {
+	t, err := toTime(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(years, months, days), nil
+}
+
+func unix(date interface{}) (int64, error) {
+	t, err := toTime(date)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+var relativeUnits = []struct {
+	size time.Duration
+	name string
+}{
+	{365 * 24 * time.Hour, "year"},
+	{30 * 24 * time.Hour, "month"},
+	{7 * 24 * time.Hour, "week"},
+	{24 * time.Hour, "day"},
+	{time.Hour, "hour"},
+	{time.Minute, "minute"},
+}
+
+// relative describes t relative to now in the largest whole unit, as in
+// "3 days ago" or "in 2 hours". Anything within a minute is "just now".
+func relative(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	for _, unit := range relativeUnits {
+		if d < unit.size {
+			continue
+		}
+		n := int64(d / unit.size)
+		text := strconv.FormatInt(n, 10) + " " + unit.name
+		if n != 1 {
+			text += "s"
+		}
+		if future {
+			return "in " + text
+		}
+		return text + " ago"
+	}
+	return "just now"
+}
@@ -0,0 +1,56 @@
+package funcs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDateFuncs(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	table, err := New(nil, Clock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2024, 1, 31, 23, 30, 0, 0, time.UTC)
+
+	tests := []funcCase{
+		{name: "formatDate RFC3339", fn: "formatDate", args: []interface{}{"2024-01-02T15:04:05Z", "Jan 2, 2006"}, expected: "Jan 2, 2024"},
+		{name: "formatDate time", fn: "formatDate", args: []interface{}{created, time.DateOnly}, expected: "2024-01-31"},
+		{name: "formatDate time pointer", fn: "formatDate", args: []interface{}{&created, "15:04"}, expected: "23:30"},
+		{name: "formatDate date only", fn: "formatDate", args: []interface{}{"2024-02-29", "Monday"}, expected: "Thursday"},
+		{name: "formatDate date and time", fn: "formatDate", args: []interface{}{"2024-02-29 08:15:00", "15:04"}, expected: "08:15"},
+		{name: "formatDate RFC1123", fn: "formatDate", args: []interface{}{"Mon, 02 Jan 2006 15:04:05 MST", "2006"}, expected: "2006"},
+		{name: "formatDate unix seconds", fn: "formatDate", args: []interface{}{int64(86400), time.DateOnly}, expected: "1970-01-02"},
+		{name: "formatDate invalid", fn: "formatDate", args: []interface{}{"not a date", "2006"}, wantErr: true},
+		{name: "formatDate nil pointer", fn: "formatDate", args: []interface{}{(*time.Time)(nil), "2006"}, wantErr: true},
+		{name: "formatDate wrong type", fn: "formatDate", args: []interface{}{true, "2006"}, wantErr: true},
+		{name: "parseDate", fn: "parseDate", args: []interface{}{"2024-02-29"}, expected: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "parseDate layout", fn: "parseDate", args: []interface{}{"29/02/2024", "02/01/2006"}, expected: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{name: "parseDate layout mismatch", fn: "parseDate", args: []interface{}{"2024-02-29", "02/01/2006"}, wantErr: true},
+		{name: "now", fn: "now", args: []interface{}{}, expected: now},
+		{name: "inZone", fn: "inZone", args: []interface{}{created, "Europe/Paris"}, expected: created.In(paris)},
+		{name: "inZone unknown zone", fn: "inZone", args: []interface{}{created, "Mars/Olympus"}, wantErr: true},
+		{name: "addDuration", fn: "addDuration", args: []interface{}{created, "1h30m"}, expected: created.Add(90 * time.Minute)},
+		{name: "addDuration days", fn: "addDuration", args: []interface{}{created, "2d12h"}, expected: created.Add(60 * time.Hour)},
+		{name: "addDuration subtract", fn: "addDuration", args: []interface{}{created, "-1d"}, expected: created.Add(-24 * time.Hour)},
+		{name: "addDuration seconds", fn: "addDuration", args: []interface{}{created, 30}, expected: created.Add(30 * time.Second)},
+		{name: "addDuration duration", fn: "addDuration", args: []interface{}{created, time.Minute}, expected: created.Add(time.Minute)},
+		{name: "addDuration invalid", fn: "addDuration", args: []interface{}{created, "soon"}, wantErr: true},
+		{name: "addDuration invalid days", fn: "addDuration", args: []interface{}{created, "xd"}, wantErr: true},
+		{name: "addDate", fn: "addDate", args: []interface{}{created, 0, 1, 1}, expected: time.Date(2024, 3, 3, 23, 30, 0, 0, time.UTC)},
+		{name: "unix", fn: "unix", args: []interface{}{"1970-01-01T00:01:00Z"}, expected: int64(60)},
+		{name: "relative past", fn: "relative", args: []interface{}{now.Add(-72 * time.Hour)}, expected: "3 days ago"},
+		{name: "relative singular", fn: "relative", args: []interface{}{now.Add(-61 * time.Minute)}, expected: "1 hour ago"},
+		{name: "relative future", fn: "relative", args: []interface{}{now.Add(2*time.Hour + time.Minute)}, expected: "in 2 hours"},
+		{name: "relative just now", fn: "relative", args: []interface{}{now.Add(-30 * time.Second)}, expected: "just now"},
+		{name: "relative weeks", fn: "relative", args: []interface{}{"2024-02-20T12:00:00Z"}, expected: "2 weeks ago"},
+		{name: "relative years", fn: "relative", args: []interface{}{"2021-03-01"}, expected: "3 years ago"},
+		{name: "relative invalid", fn: "relative", args: []interface{}{"yesterday"}, wantErr: true},
+	}
+
+	runFuncCases(t, table, tests)
+}
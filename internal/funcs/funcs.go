@@ -0,0 +1,323 @@
+package funcs
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Func is a Go function that templates can call. Calls with common
+// signatures go through a direct wrapper; everything else goes through
+// reflection, converting the arguments to the parameter types.
+type Func struct {
+	name    string
+	value   reflect.Value
+	typ     reflect.Type
+	fast    func(args []interface{}) (interface{}, bool)
+	failing bool
+}
+
+// Table holds the functions available to a template. The compiler resolves a
+// name to its index once, so calls at runtime are a slice lookup.
+type Table struct {
+	funcs []Func
+	index map[string]int
+}
+
+var builtins = map[string]any{
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"default": defaultValue,
+}
+
+// Group is a set of optional built-in functions.
+type Group uint8
+
+const (
+	// Strings are trim, replace, split, truncate, wrap and the other string
+	// helpers.
+	Strings Group = 1 << iota
+)
+
+var groups = map[Group]map[string]any{
+	Strings: stringFuncs,
+}
+
+type config struct {
+	omit  Group
+	clock func() time.Time
+}
+
+// Option configures the built-in functions of a table.
+type Option func(*config)
+
+// Omit leaves the built-in functions of group out of the table.
+func Omit(group Group) Option {
+	return func(c *config) {
+		c.omit |= group
+	}
+}
+
+// Clock sets the function now and relative read the current time from,
+// which is time.Now by default.
+func Clock(now func() time.Time) Option {
+	return func(c *config) {
+		if now != nil {
+			c.clock = now
+		}
+	}
+}
+
+var defaultTable = mustNew(nil)
+
+// Default returns the table with only the built-in functions.
+func Default() *Table {
+	return defaultTable
+}
+
+// New returns a table with the built-in functions and funcs, which take
+// precedence over built-ins of the same name. Every value must be a function
+// returning one value, or a value and an error.
+func New(funcs map[string]any, opts ...Option) (*Table, error) {
+	cfg := config{clock: time.Now}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	all := make(map[string]any, len(builtins)+len(funcs))
+	for _, fns := range []map[string]any{builtins, mathFuncs, collectionFuncs, dateFuncs(cfg.clock)} {
+		for name, fn := range fns {
+			all[name] = fn
+		}
+	}
+	for g, fns := range groups {
+		if cfg.omit&g != 0 {
+			continue
+		}
+		for name, fn := range fns {
+			all[name] = fn
+		}
+	}
+	for name, fn := range funcs {
+		all[name] = fn
+	}
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	t := &Table{
+		funcs: make([]Func, 0, len(names)),
+		index: make(map[string]int, len(names)),
+	}
+	for _, name := range names {
+		fn, err := newFunc(name, all[name])
+		if err != nil {
+			return nil, err
+		}
+		t.index[name] = len(t.funcs)
+		t.funcs = append(t.funcs, fn)
+	}
+	return t, nil
+}
+
+func mustNew(funcs map[string]any) *Table {
+	t, err := New(funcs)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func newFunc(name string, fn any) (Func, error) {
+	value := reflect.ValueOf(fn)
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return Func{}, fmt.Errorf("function %s is a %T, not a function", name, fn)
+	}
+	typ := value.Type()
+	switch {
+	case typ.NumOut() == 1:
+		return Func{name: name, value: value, typ: typ, fast: fastPath(fn)}, nil
+	case typ.NumOut() == 2 && typ.Out(1) == errorType:
+		return Func{name: name, value: value, typ: typ, failing: true}, nil
+	default:
+		return Func{}, fmt.Errorf("function %s must return one value or a value and an error, returns %d values", name, typ.NumOut())
+	}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// fastPath wraps the signatures most template functions have so they can be
+// called without reflection. The wrapper reports false when the arguments do
+// not have the exact types, leaving conversion to the reflective call.
+func fastPath(fn any) func(args []interface{}) (interface{}, bool) {
+	switch f := fn.(type) {
+	case func(string) string:
+		return func(args []interface{}) (interface{}, bool) {
+			s, ok := args[0].(string)
+			if !ok {
+				return nil, false
+			}
+			return f(s), true
+		}
+	case func(string, string) string:
+		return func(args []interface{}) (interface{}, bool) {
+			a, ok := args[0].(string)
+			if !ok {
+				return nil, false
+			}
+			b, ok := args[1].(string)
+			if !ok {
+				return nil, false
+			}
+			return f(a, b), true
+		}
+	case func(interface{}) interface{}:
+		return func(args []interface{}) (interface{}, bool) {
+			return f(args[0]), true
+		}
+	case func(...interface{}) interface{}:
+		return func(args []interface{}) (interface{}, bool) {
+			return f(args...), true
+		}
+	default:
+		return nil
+	}
+}
+
+// Lookup returns the index of the function called name.
+func (t *Table) Lookup(name string) (int, bool) {
+	index, ok := t.index[name]
+	return index, ok
+}
+
+// CheckArity reports an error unless the function at index can be called
+// with n arguments.
+func (t *Table) CheckArity(index, n int) error {
+	return t.funcs[index].checkArity(n)
+}
+
+func (fn *Func) checkArity(n int) error {
+	want := fn.typ.NumIn()
+	if fn.typ.IsVariadic() {
+		if n < want-1 {
+			return fmt.Errorf("function %s takes at least %d arguments, got %d", fn.name, want-1, n)
+		}
+		return nil
+	}
+	if n != want {
+		return fmt.Errorf("function %s takes %d arguments, got %d", fn.name, want, n)
+	}
+	return nil
+}
+
+// TakesNoArguments reports whether the function at index has no parameters,
+// so that templates can call it by its bare name, as in {{ now }}.
+func (t *Table) TakesNoArguments(index int) bool {
+	return t.funcs[index].typ.NumIn() == 0
+}
+
+// Name returns the name of the function at index.
+func (t *Table) Name(index int) string {
+	return t.funcs[index].name
+}
+
+// Call calls the function at index with args. The error is the one returned
+// by the function, if it has an error result, or describes an argument that
+// could not be converted or a panic inside the function.
+func (t *Table) Call(index int, args []interface{}) (result interface{}, err error) {
+	if index >= len(t.funcs) {
+		return nil, fmt.Errorf("unknown function index %d", index)
+	}
+	fn := &t.funcs[index]
+	if err := fn.checkArity(len(args)); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if fn.fast != nil {
+		if result, ok := fn.fast(args); ok {
+			return result, nil
+		}
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		v, err := convertArg(arg, fn.paramType(i))
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		in[i] = v
+	}
+
+	out := fn.value.Call(in)
+	if fn.failing && !out[1].IsNil() {
+		return nil, out[1].Interface().(error)
+	}
+	return out[0].Interface(), nil
+}
+
+// paramType returns the type argument i is converted to, which for the
+// trailing arguments of a variadic function is the element type.
+func (fn *Func) paramType(i int) reflect.Type {
+	last := fn.typ.NumIn() - 1
+	if fn.typ.IsVariadic() && i >= last {
+		return fn.typ.In(last).Elem()
+	}
+	return fn.typ.In(i)
+}
+
+// convertArg converts a template value to a parameter type. nil becomes the
+// zero value and numbers convert between numeric kinds, so an integer literal
+// can be passed to an int, int32 or float64 parameter.
+func convertArg(arg interface{}, typ reflect.Type) (reflect.Value, error) {
+	if arg == nil {
+		return reflect.Zero(typ), nil
+	}
+	v := reflect.ValueOf(arg)
+	if v.Type().AssignableTo(typ) {
+		return v, nil
+	}
+	if isNumeric(v.Kind()) && isNumeric(typ.Kind()) {
+		return v.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %T as %s", arg, typ)
+}
+
+func isNumeric(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
+
+// defaultValue returns fallback when value is empty: nil, a nil pointer, an
+// empty string or an empty slice, array or map. Numbers and booleans are
+// never empty, so a count of 0 is kept.
+func defaultValue(value, fallback interface{}) interface{} {
+	if isEmpty(value) {
+		return fallback
+	}
+	return value
+}
+
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package funcs
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTableCall(t *testing.T) {
+	table, err := New(map[string]any{
+		"concat": func(a, b string) string { return a + b },
+		"double": func(n int) int { return n * 2 },
+		"kind":   func(v interface{}) interface{} { return v == nil },
+		"join":   func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+		"count":  func(values ...interface{}) interface{} { return len(values) },
+		"parse":  strconv.Atoi,
+		"boom":   func(s string) string { panic("boom " + s) },
+		"crash":  func(n int) int { panic("crash") },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []funcCase{
+		{name: "builtin", fn: "upper", args: []interface{}{"a"}, expected: "A"},
+		{name: "two strings", fn: "concat", args: []interface{}{"a", "b"}, expected: "ab"},
+		{name: "int64 to int", fn: "double", args: []interface{}{int64(2)}, expected: 4},
+		{name: "float to int", fn: "double", args: []interface{}{2.9}, expected: 4},
+		{name: "nil to zero value", fn: "double", args: []interface{}{nil}, expected: 0},
+		{name: "any parameter", fn: "kind", args: []interface{}{nil}, expected: true},
+		{name: "variadic", fn: "join", args: []interface{}{"-", "a", "b", "c"}, expected: "a-b-c"},
+		{name: "variadic without extra arguments", fn: "join", args: []interface{}{"-"}, expected: ""},
+		{name: "variadic with wrong element type", fn: "join", args: []interface{}{"-", "a", 1}, wantErr: true},
+		{name: "variadic any", fn: "count", args: []interface{}{1, "a", nil}, expected: 3},
+		{name: "wrong type", fn: "upper", args: []interface{}{1}, wantErr: true},
+		{name: "wrong arity", fn: "concat", args: []interface{}{"a"}, wantErr: true},
+		{name: "default for nil", fn: "default", args: []interface{}{nil, "x"}, expected: "x"},
+		{name: "default for empty string", fn: "default", args: []interface{}{"", "x"}, expected: "x"},
+		{name: "default for empty slice", fn: "default", args: []interface{}{[]int{}, "x"}, expected: "x"},
+		{name: "default for nil pointer", fn: "default", args: []interface{}{(*int)(nil), 1}, expected: 1},
+		{name: "default keeps zero", fn: "default", args: []interface{}{0, 5}, expected: 0},
+		{name: "default keeps false", fn: "default", args: []interface{}{false, true}, expected: false},
+		{name: "default keeps value", fn: "default", args: []interface{}{"a", "x"}, expected: "a"},
+		{name: "value and nil error", fn: "parse", args: []interface{}{"42"}, expected: 42},
+		{name: "returned error", fn: "parse", args: []interface{}{"x"}, wantErr: true},
+		{name: "builtin returned error", fn: "formatDate", args: []interface{}{"yesterday", "2006"}, wantErr: true},
+		{name: "panic on fast path", fn: "boom", args: []interface{}{"a"}, wantErr: true},
+		{name: "panic on reflective call", fn: "crash", args: []interface{}{1}, wantErr: true},
+	}
+
+	runFuncCases(t, table, tests)
+}
+
+func TestTableCheckArity(t *testing.T) {
+	table := Default()
+	index, _ := table.Lookup("formatDate")
+	if err := table.CheckArity(index, 2); err != nil {
+		t.Errorf("CheckArity(2) = %v", err)
+	}
+	if err := table.CheckArity(index, 1); err == nil {
+		t.Error("CheckArity(1) expected an error")
+	}
+
+	table, err := New(map[string]any{"join": func(sep string, parts ...string) string { return "" }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, _ = table.Lookup("join")
+	for n, ok := range map[int]bool{0: false, 1: true, 2: true, 20: true} {
+		if err := table.CheckArity(index, n); (err == nil) != ok {
+			t.Errorf("CheckArity(%d) = %v, want ok %v", n, err, ok)
+		}
+	}
+}
+
+func TestNewRejectsInvalidFunctions(t *testing.T) {
+	for name, fn := range map[string]any{
+		"nil":         nil,
+		"string":      "x",
+		"nil func":    (func())(nil),
+		"no results":  func() {},
+		"error first": func() (error, string) { return nil, "" },
+		"two values":  func() (string, string) { return "", "" },
+	} {
+		if _, err := New(map[string]any{"fn": fn}); err == nil {
+			t.Errorf("%s: New() expected an error", name)
+		}
+	}
+}
+
+// funcCase is a call of a template function with its expected result.
+type funcCase struct {
+	name     string
+	fn       string
+	args     []interface{}
+	expected interface{}
+	wantErr  bool
+}
+
+// callFunc calls the function of table named name with args.
+func callFunc(t *testing.T, table *Table, name string, args ...interface{}) (interface{}, error) {
+	t.Helper()
+	index, ok := table.Lookup(name)
+	if !ok {
+		t.Fatalf("Lookup(%q) failed", name)
+	}
+	return table.Call(index, args)
+}
+
+// runFuncCases runs each case as a subtest. Times are expected to be equal
+// and in the same zone; anything else deeply equal.
+func runFuncCases(t *testing.T, table *Table, tests []funcCase) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := callFunc(t, table, tt.fn, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Call() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want, ok := tt.expected.(time.Time); ok {
+				if got, ok := got.(time.Time); !ok || !got.Equal(want) || got.Location().String() != want.Location().String() {
+					t.Errorf("Call() = %v, want %v", got, want)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Call() = %#v, want %#v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package funcs
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/flothq/swap/internal/values"
+)
+
+// mathFuncs round and format numbers. They take ints, int64s, float64s, any
+// other Go number and numeric strings; the rounding functions return a value
+// of the same kind, with numeric strings read as an int or a float64.
+var mathFuncs = map[string]any{
+	"round":     round,
+	"floor":     floor,
+	"ceil":      ceil,
+	"abs":       abs,
+	"min":       minimum,
+	"max":       maximum,
+	"fixed":     fixed,
+	"thousands": thousands,
+	"percent":   percent,
+}
+
+// maxPlaces bounds the number of decimal places the functions accept.
+const maxPlaces = 20
+
+// toNumber converts a Go number or a numeric string to a number. Numeric
+// strings are read as an int or a float64.
+func toNumber(value interface{}) (values.Number, error) {
+	switch v := value.(type) {
+	case string:
+		s := strings.TrimSpace(v)
+		if i, err := strconv.ParseInt(s, 10, strconv.IntSize); err == nil {
+			return values.Number{I: i, Kind: values.KindInt}, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return values.Number{F: f, Kind: values.KindFloat}, nil
+		}
+		return values.Number{}, fmt.Errorf("%q is not a number", v)
+	case nil, bool:
+		return values.Number{}, fmt.Errorf("%v is not a number", v)
+	}
+	if n, ok := values.ToNumber(value); ok {
+		return n, nil
+	}
+	return values.Number{}, fmt.Errorf("%T is not a number", value)
+}
+
+// decimalPlaces returns the optional number of decimal places of round and
+// percent, which is zero when it is left out.
+func decimalPlaces(places []int) (int, error) {
+	switch len(places) {
+	case 0:
+		return 0, nil
+	case 1:
+		return checkPlaces(places[0])
+	default:
+		return 0, fmt.Errorf("expected at most one number of decimal places, got %d", len(places))
+	}
+}
+
+func checkPlaces(places int) (int, error) {
+	if places < 0 || places > maxPlaces {
+		return 0, fmt.Errorf("decimal places %d out of range [0, %d]", places, maxPlaces)
+	}
+	return places, nil
+}
+
+// roundFloat rounds f half away from zero to places decimal places.
+func roundFloat(f float64, places int) float64 {
+	if places == 0 {
+		return math.Round(f)
+	}
+	pow := math.Pow10(places)
+	if scaled := f * pow; !math.IsInf(scaled, 0) {
+		return math.Round(scaled) / pow
+	}
+	return f
+}
+
+// round rounds v half away from zero, to an integer or to the given number
+// of decimal places. Integers are returned unchanged.
+func round(v interface{}, places ...int) (interface{}, error) {
+	n, err := toNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decimalPlaces(places)
+	if err != nil {
+		return nil, err
+	}
+	if n.Kind == values.KindFloat {
+		n.F = roundFloat(n.F, p)
+	}
+	return n.Value(), nil
+}
+
+func floor(v interface{}) (interface{}, error) {
+	n, err := toNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	n.F = math.Floor(n.F)
+	return n.Value(), nil
+}
+
+func ceil(v interface{}) (interface{}, error) {
+	n, err := toNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	n.F = math.Ceil(n.F)
+	return n.Value(), nil
+}
+
+func abs(v interface{}) (interface{}, error) {
+	n, err := toNumber(v)
+	if err != nil {
+		return nil, err
+	}
+	if n.I == math.MinInt64 && n.Kind != values.KindFloat {
+		return nil, fmt.Errorf("abs(%d) overflows", n.I)
+	}
+	if n.I < 0 {
+		n.I = -n.I
+	}
+	n.F = math.Abs(n.F)
+	return n.Value(), nil
+}
+
+func minimum(first interface{}, rest ...interface{}) (interface{}, error) {
+	return pick(first, rest, -1)
+}
+
+func maximum(first interface{}, rest ...interface{}) (interface{}, error) {
+	return pick(first, rest, 1)
+}
+
+// pick returns the smallest (sign -1) or largest (sign 1) of the values,
+// comparing integers exactly and mixed integers and floats as floats.
+func pick(first interface{}, rest []interface{}, sign int) (interface{}, error) {
+	best, err := toNumber(first)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range rest {
+		n, err := toNumber(v)
+		if err != nil {
+			return nil, err
+		}
+		if values.CompareNumbers(n, best) == sign {
+			best = n
+		}
+	}
+	return best.Value(), nil
+}
+
+// formatFixed formats n with exactly places decimals, rounding half away from
+// zero like round.
+func formatFixed(n values.Number, places int) string {
+	if n.Kind != values.KindFloat {
+		s := strconv.FormatInt(n.I, 10)
+		if places > 0 {
+			s += "." + strings.Repeat("0", places)
+		}
+		return s
+	}
+	return strconv.FormatFloat(roundFloat(n.F, places), 'f', places, 64)
+}
+
+// fixed formats v with exactly places decimals: fixed(3.14159, 2) is "3.14"
+// and fixed(3, 2) is "3.00".
+func fixed(v interface{}, places int) (string, error) {
+	n, err := toNumber(v)
+	if err != nil {
+		return "", err
+	}
+	if places, err = checkPlaces(places); err != nil {
+		return "", err
+	}
+	return formatFixed(n, places), nil
+}
+
+// percent formats a ratio as a percentage: percent(0.256) is "26%" and
+// percent(0.256, 1) is "25.6%".
+func percent(v interface{}, places ...int) (string, error) {
+	n, err := toNumber(v)
+	if err != nil {
+		return "", err
+	}
+	p, err := decimalPlaces(places)
+	if err != nil {
+		return "", err
+	}
+	return formatFixed(values.Number{F: n.Float() * 100, Kind: values.KindFloat}, p) + "%", nil
+}
+
+// thousands groups the integer digits of v in threes with sep, "," by
+// default: thousands(1234567.5) is "1,234,567.5". A numeric string keeps its
+// decimals as written, so thousands(fixed(.total, 2)) works as expected.
+func thousands(v interface{}, sep ...string) (string, error) {
+	separator := ","
+	switch len(sep) {
+	case 0:
+	case 1:
+		separator = sep[0]
+	default:
+		return "", fmt.Errorf("expected at most one separator, got %d", len(sep))
+	}
+
+	n, err := toNumber(v)
+	if err != nil {
+		return "", err
+	}
+	if math.IsInf(n.F, 0) || math.IsNaN(n.F) {
+		return values.String(n.F), nil
+	}
+	s, ok := v.(string)
+	s = strings.TrimPrefix(strings.TrimSpace(s), "+")
+	if !ok || strings.ContainsFunc(s, isNotDecimal) {
+		s = values.String(n.Value())
+	}
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	digits, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits, fraction = s[:i], s[i:]
+	}
+
+	var sb strings.Builder
+	sb.WriteString(sign)
+	for i := 0; i < len(digits); i++ {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			sb.WriteString(separator)
+		}
+		sb.WriteByte(digits[i])
+	}
+	sb.WriteString(fraction)
+	return sb.String(), nil
+}
+
+// isNotDecimal reports whether r cannot appear in a plain decimal number,
+// like the exponent of "1e3".
+func isNotDecimal(r rune) bool {
+	return (r < '0' || r > '9') && r != '.' && r != '-'
+}
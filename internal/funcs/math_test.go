@@ -0,0 +1,54 @@
+package funcs
+
+import (
+	"testing"
+)
+
+func TestMathFuncs(t *testing.T) {
+	tests := []funcCase{
+		{name: "round float", fn: "round", args: []interface{}{2.5}, expected: 3.0},
+		{name: "round negative float", fn: "round", args: []interface{}{-2.5}, expected: -3.0},
+		{name: "round to places", fn: "round", args: []interface{}{3.14159, 2}, expected: 3.14},
+		{name: "round int", fn: "round", args: []interface{}{7}, expected: 7},
+		{name: "round int64", fn: "round", args: []interface{}{int64(7), 1}, expected: int64(7)},
+		{name: "round numeric string", fn: "round", args: []interface{}{" 1.25 ", 1}, expected: 1.3},
+		{name: "round integer string", fn: "round", args: []interface{}{"12"}, expected: 12},
+		{name: "round too many places", fn: "round", args: []interface{}{1.5, 21}, wantErr: true},
+		{name: "round negative places", fn: "round", args: []interface{}{1.5, -1}, wantErr: true},
+		{name: "round two place arguments", fn: "round", args: []interface{}{1.5, 1, 2}, wantErr: true},
+		{name: "round non-number", fn: "round", args: []interface{}{"abc"}, wantErr: true},
+		{name: "round nil", fn: "round", args: []interface{}{nil}, wantErr: true},
+		{name: "floor", fn: "floor", args: []interface{}{-1.5}, expected: -2.0},
+		{name: "floor int", fn: "floor", args: []interface{}{-1}, expected: -1},
+		{name: "floor uint8", fn: "floor", args: []interface{}{uint8(3)}, expected: int64(3)},
+		{name: "ceil", fn: "ceil", args: []interface{}{1.2}, expected: 2.0},
+		{name: "ceil string", fn: "ceil", args: []interface{}{"1.2"}, expected: 2.0},
+		{name: "abs int", fn: "abs", args: []interface{}{-4}, expected: 4},
+		{name: "abs int64", fn: "abs", args: []interface{}{int64(-4)}, expected: int64(4)},
+		{name: "abs float", fn: "abs", args: []interface{}{-0.5}, expected: 0.5},
+		{name: "abs overflow", fn: "abs", args: []interface{}{int64(-1 << 63)}, wantErr: true},
+		{name: "min ints", fn: "min", args: []interface{}{3, 1, 2}, expected: 1},
+		{name: "min mixed", fn: "min", args: []interface{}{3, 2.5, int64(4)}, expected: 2.5},
+		{name: "min single", fn: "min", args: []interface{}{"9"}, expected: 9},
+		{name: "max mixed", fn: "max", args: []interface{}{1.5, "2", int64(-1)}, expected: 2},
+		{name: "max non-number", fn: "max", args: []interface{}{1, "x"}, wantErr: true},
+		{name: "fixed", fn: "fixed", args: []interface{}{3.14159, 2}, expected: "3.14"},
+		{name: "fixed rounds half up", fn: "fixed", args: []interface{}{2.5, 0}, expected: "3"},
+		{name: "fixed int", fn: "fixed", args: []interface{}{3, 2}, expected: "3.00"},
+		{name: "fixed string", fn: "fixed", args: []interface{}{"0.1", 3}, expected: "0.100"},
+		{name: "fixed negative places", fn: "fixed", args: []interface{}{1.0, -2}, wantErr: true},
+		{name: "thousands int", fn: "thousands", args: []interface{}{1234567}, expected: "1,234,567"},
+		{name: "thousands small", fn: "thousands", args: []interface{}{999}, expected: "999"},
+		{name: "thousands negative", fn: "thousands", args: []interface{}{-1234.5}, expected: "-1,234.5"},
+		{name: "thousands separator", fn: "thousands", args: []interface{}{int64(1000000), "."}, expected: "1.000.000"},
+		{name: "thousands keeps string decimals", fn: "thousands", args: []interface{}{"1234.50"}, expected: "1,234.50"},
+		{name: "thousands exponent string", fn: "thousands", args: []interface{}{"1e6"}, expected: "1,000,000"},
+		{name: "thousands non-number", fn: "thousands", args: []interface{}{"12a"}, wantErr: true},
+		{name: "percent", fn: "percent", args: []interface{}{0.256}, expected: "26%"},
+		{name: "percent places", fn: "percent", args: []interface{}{0.256, 1}, expected: "25.6%"},
+		{name: "percent int", fn: "percent", args: []interface{}{1}, expected: "100%"},
+		{name: "percent string", fn: "percent", args: []interface{}{"0.5", 2}, expected: "50.00%"},
+	}
+
+	runFuncCases(t, Default(), tests)
+}
@@ -0,0 +1,154 @@
+package funcs
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// stringFuncs are the built-in string helpers. Their first parameter is the
+// string they work on, so they read naturally in pipelines:
+// {{ .title | truncate(20) | padRight(22) }}.
+var stringFuncs = map[string]any{
+	"trim":       strings.TrimSpace,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"replace":    strings.ReplaceAll,
+	"split":      split,
+	"contains":   strings.Contains,
+	"hasPrefix":  strings.HasPrefix,
+	"hasSuffix":  strings.HasSuffix,
+	"repeat":     repeat,
+	"title":      title,
+	"truncate":   truncate,
+	"padLeft":    padLeft,
+	"padRight":   padRight,
+	"indent":     indent,
+	"wrap":       wrap,
+}
+
+// ellipsis replaces the end of a truncated string.
+const ellipsis = "…"
+
+// split splits s around each sep. Unlike strings.Split, an empty s gives no
+// elements, so ranging over the result of splitting an empty value does
+// nothing.
+func split(s, sep string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, sep)
+}
+
+func repeat(s string, count int) (string, error) {
+	if count < 0 {
+		return "", fmt.Errorf("negative repeat count %d", count)
+	}
+	if count > 0 && len(s)*count/count != len(s) {
+		return "", fmt.Errorf("repeat count %d too large", count)
+	}
+	return strings.Repeat(s, count), nil
+}
+
+// title upper-cases the first letter of every word and leaves the rest as
+// they are.
+func title(s string) string {
+	start := true
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '-' || r == '_' {
+			start = true
+			return r
+		}
+		if start {
+			start = false
+			return unicode.ToTitle(r)
+		}
+		return r
+	}, s)
+}
+
+// truncate shortens s to at most n characters, the last of which is an
+// ellipsis when anything was cut.
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	i, count := 0, 0
+	for i = range s {
+		if count == n-1 {
+			break
+		}
+		count++
+	}
+	return s[:i] + ellipsis
+}
+
+// padLeft right-aligns s in a field of width characters by prepending spaces.
+// Longer strings are returned unchanged.
+func padLeft(s string, width int) string {
+	if n := width - utf8.RuneCountInString(s); n > 0 {
+		return strings.Repeat(" ", n) + s
+	}
+	return s
+}
+
+// padRight left-aligns s in a field of width characters by appending spaces.
+func padRight(s string, width int) string {
+	if n := width - utf8.RuneCountInString(s); n > 0 {
+		return s + strings.Repeat(" ", n)
+	}
+	return s
+}
+
+// indent prefixes every non-empty line of s with n spaces.
+func indent(s string, n int) string {
+	if n <= 0 || s == "" {
+		return s
+	}
+	pad := strings.Repeat(" ", n)
+	lines := strings.SplitAfter(s, "\n")
+	var sb strings.Builder
+	sb.Grow(len(s) + len(lines)*n)
+	for _, line := range lines {
+		if line != "" && line != "\n" {
+			sb.WriteString(pad)
+		}
+		sb.WriteString(line)
+	}
+	return sb.String()
+}
+
+// wrap breaks s into lines of at most width characters at spaces. Words
+// longer than width get a line of their own; existing line breaks are kept.
+func wrap(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			sb.WriteByte('\n')
+		}
+		col := 0
+		for _, word := range strings.Fields(line) {
+			n := utf8.RuneCountInString(word)
+			switch {
+			case col == 0:
+			case col+1+n > width:
+				sb.WriteByte('\n')
+				col = 0
+			default:
+				sb.WriteByte(' ')
+				col++
+			}
+			sb.WriteString(word)
+			col += n
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,60 @@
+package funcs
+
+import "testing"
+
+func TestStringFuncs(t *testing.T) {
+	tests := []funcCase{
+		{name: "trim", fn: "trim", args: []interface{}{"  a b \n"}, expected: "a b"},
+		{name: "trimPrefix", fn: "trimPrefix", args: []interface{}{"v1.2", "v"}, expected: "1.2"},
+		{name: "trimPrefix without prefix", fn: "trimPrefix", args: []interface{}{"1.2", "v"}, expected: "1.2"},
+		{name: "trimSuffix", fn: "trimSuffix", args: []interface{}{"report.pdf", ".pdf"}, expected: "report"},
+		{name: "replace", fn: "replace", args: []interface{}{"a-b-c", "-", "+"}, expected: "a+b+c"},
+		{name: "split", fn: "split", args: []interface{}{"a,b,,c", ","}, expected: []string{"a", "b", "", "c"}},
+		{name: "split empty string", fn: "split", args: []interface{}{"", ","}, expected: []string(nil)},
+		{name: "contains", fn: "contains", args: []interface{}{"haystack", "st"}, expected: true},
+		{name: "contains missing", fn: "contains", args: []interface{}{"haystack", "x"}, expected: false},
+		{name: "hasPrefix", fn: "hasPrefix", args: []interface{}{"https://x", "https://"}, expected: true},
+		{name: "hasSuffix", fn: "hasSuffix", args: []interface{}{"a.go", ".txt"}, expected: false},
+		{name: "repeat", fn: "repeat", args: []interface{}{"ab", 3}, expected: "ababab"},
+		{name: "repeat zero", fn: "repeat", args: []interface{}{"ab", 0}, expected: ""},
+		{name: "repeat negative", fn: "repeat", args: []interface{}{"ab", -1}, wantErr: true},
+		{name: "title", fn: "title", args: []interface{}{"hello wide-world of éclairs"}, expected: "Hello Wide-World Of Éclairs"},
+		{name: "title keeps case", fn: "title", args: []interface{}{"iPhone and NASA"}, expected: "IPhone And NASA"},
+		{name: "truncate", fn: "truncate", args: []interface{}{"Hello, world", 8}, expected: "Hello, …"},
+		{name: "truncate short string", fn: "truncate", args: []interface{}{"Hello", 5}, expected: "Hello"},
+		{name: "truncate runes", fn: "truncate", args: []interface{}{"ééééé", 3}, expected: "éé…"},
+		{name: "truncate to one", fn: "truncate", args: []interface{}{"abc", 1}, expected: "…"},
+		{name: "truncate to zero", fn: "truncate", args: []interface{}{"abc", 0}, expected: ""},
+		{name: "padLeft", fn: "padLeft", args: []interface{}{"42", 5}, expected: "   42"},
+		{name: "padLeft longer string", fn: "padLeft", args: []interface{}{"123456", 5}, expected: "123456"},
+		{name: "padRight", fn: "padRight", args: []interface{}{"né", 4}, expected: "né  "},
+		{name: "indent", fn: "indent", args: []interface{}{"a\n\nb\n", 2}, expected: "  a\n\n  b\n"},
+		{name: "indent zero", fn: "indent", args: []interface{}{"a\nb", 0}, expected: "a\nb"},
+		{name: "wrap", fn: "wrap", args: []interface{}{"the quick brown fox jumps", 10}, expected: "the quick\nbrown fox\njumps"},
+		{name: "wrap long word", fn: "wrap", args: []interface{}{"a extraordinarily b", 5}, expected: "a\nextraordinarily\nb"},
+		{name: "wrap keeps line breaks", fn: "wrap", args: []interface{}{"one two\nthree", 20}, expected: "one two\nthree"},
+		{name: "wrap collapses spaces", fn: "wrap", args: []interface{}{"a   b", 10}, expected: "a b"},
+	}
+
+	runFuncCases(t, Default(), tests)
+}
+
+func TestNewOmitsGroups(t *testing.T) {
+	table, err := New(map[string]any{"trim": func(s string) string { return "custom" }}, Omit(Strings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := table.Lookup("wrap"); ok {
+		t.Error("Lookup(wrap) found an omitted function")
+	}
+	if _, ok := table.Lookup("upper"); !ok {
+		t.Error("Lookup(upper) failed")
+	}
+	index, ok := table.Lookup("trim")
+	if !ok {
+		t.Fatal("Lookup(trim) failed")
+	}
+	if got, _ := table.Call(index, []interface{}{" a "}); got != "custom" {
+		t.Errorf("trim = %v, want the custom function", got)
+	}
+}
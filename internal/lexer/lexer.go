@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 )
 
@@ -10,6 +11,7 @@ type Lexer struct {
 	pos    int
 	start  int
 	tokens []Token
+	err    error
 }
 
 var lexerPool = sync.Pool{
@@ -26,6 +28,7 @@ func NewLexer(input string) *Lexer {
 	lexer.pos = 0
 	lexer.start = 0
 	lexer.tokens = lexer.tokens[:0]
+	lexer.err = nil
 	return lexer
 }
 
@@ -33,11 +36,14 @@ func (l *Lexer) Release() {
 	l.input = ""
 	l.pos = 0
 	l.start = 0
+	l.err = nil
 	lexerPool.Put(l)
 }
 
-func (l *Lexer) Lex() []Token {
-	for l.pos < len(l.input) {
+// Lex splits the input into tokens, ending with TokenEOF. It stops at the
+// first character that cannot start a token and at an unterminated string.
+func (l *Lexer) Lex() ([]Token, error) {
+	for l.pos < len(l.input) && l.err == nil {
 		if l.input[l.pos] == '{' && l.peek() == '{' {
 			l.pos += 2
 			l.addToken(TokenLDelim)
@@ -51,11 +57,21 @@ func (l *Lexer) Lex() []Token {
 		l.addToken(TokenEOF)
 	}
 
-	return l.tokens
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.tokens, nil
+}
+
+// errorf records an error at the current position, which ends lexing.
+func (l *Lexer) errorf(format string, args ...interface{}) {
+	line := strings.Count(l.input[:l.pos], "\n") + 1
+	l.err = fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+	l.pos = len(l.input)
 }
 
 func (l *Lexer) lexInsideDelimiter() {
-	for l.pos < len(l.input) {
+	for l.pos < len(l.input) && l.err == nil {
 		if l.input[l.pos] == '}' && l.peek() == '}' {
 			l.pos += 2
 			l.addToken(TokenRDelim)
@@ -67,6 +83,8 @@ func (l *Lexer) lexInsideDelimiter() {
 			l.lexSpace()
 		case l.input[l.pos] == '.':
 			l.lexAccessor()
+		case l.input[l.pos] == '$':
+			l.lexVariable()
 		case l.input[l.pos] == '"' || l.input[l.pos] == '\'':
 			l.lexString()
 		case isLetter(l.input[l.pos]):
@@ -82,8 +100,16 @@ func (l *Lexer) lexInsideDelimiter() {
 		case l.input[l.pos] == ',':
 			l.pos++
 			l.addToken(TokenComma)
+		case l.input[l.pos] == '[':
+			l.pos++
+			l.addToken(TokenLBracket)
+		case l.input[l.pos] == ']':
+			l.pos++
+			l.addToken(TokenRBracket)
+		case isOperator(l.input[l.pos]):
+			l.lexOperator()
 		default:
-			panic(fmt.Sprintf("unknown token %c", l.input[l.pos]))
+			l.errorf("unexpected character %q", l.input[l.pos])
 		}
 	}
 }
@@ -111,7 +137,9 @@ func (l *Lexer) lexString() {
 		l.pos++
 	}
 	if l.pos >= len(l.input) {
-		panic("unterminated string")
+		l.pos = start - 1
+		l.errorf("unterminated string")
+		return
 	}
 	l.pos++
 	l.tokens = append(l.tokens, Token{Type: TokenLiteralString, Value: l.input[start : l.pos-1]})
@@ -120,12 +148,41 @@ func (l *Lexer) lexString() {
 
 func (l *Lexer) lexAccessor() {
 	l.pos++
-	for l.pos < len(l.input) && !isSpace(l.input[l.pos]) && l.input[l.pos] != '}' && l.input[l.pos] != ')' && l.input[l.pos] != '(' && l.input[l.pos] != ',' {
+	for l.pos < len(l.input) && (isLetter(l.input[l.pos]) || isDigit(l.input[l.pos]) || l.input[l.pos] == '.') {
 		l.pos++
 	}
 	l.addToken(TokenAccessor)
 }
 
+// lexVariable lexes $name, $name.field and a bare $.
+func (l *Lexer) lexVariable() {
+	l.pos++
+	for l.pos < len(l.input) && (isLetter(l.input[l.pos]) || isDigit(l.input[l.pos]) || l.input[l.pos] == '.') {
+		l.pos++
+	}
+	l.addToken(TokenVariable)
+}
+
+func (l *Lexer) lexOperator() {
+	two := ""
+	if l.pos+1 < len(l.input) {
+		two = l.input[l.pos : l.pos+2]
+	}
+	switch two {
+	case "==", "!=", "<=", ">=", "&&", "||", ":=", "??":
+		l.pos += 2
+		l.addToken(TokenOperator)
+		return
+	}
+	switch l.input[l.pos] {
+	case '!', '<', '>', '+', '-', '*', '/', '%', '|', '=':
+		l.pos++
+		l.addToken(TokenOperator)
+	default:
+		l.errorf("unexpected character %q", l.input[l.pos])
+	}
+}
+
 func (l *Lexer) lexText() {
 	for l.pos < len(l.input) && (l.input[l.pos] != '{' || l.peek() != '{') {
 		l.pos++
@@ -140,7 +197,14 @@ func (l *Lexer) lexIdentifier() {
 	for l.pos < len(l.input) && (isLetter(l.input[l.pos]) || isDigit(l.input[l.pos])) {
 		l.pos++
 	}
-	l.addToken(TokenIdentifier)
+	switch l.input[l.start:l.pos] {
+	case "true", "false":
+		l.addToken(TokenLiteralBoolean)
+	case "nil":
+		l.addToken(TokenLiteralNil)
+	default:
+		l.addToken(TokenIdentifier)
+	}
 }
 
 func (l *Lexer) lexNumber() {
@@ -148,6 +212,12 @@ func (l *Lexer) lexNumber() {
 	for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
 		l.pos++
 	}
+	if l.pos+1 < len(l.input) && l.input[l.pos] == '.' && isDigit(l.input[l.pos+1]) {
+		l.pos++
+		for l.pos < len(l.input) && isDigit(l.input[l.pos]) {
+			l.pos++
+		}
+	}
 	l.addToken(TokenLiteralNumber)
 }
 
@@ -171,6 +241,14 @@ func isDigit(ch byte) bool {
 	return ch >= '0' && ch <= '9'
 }
 
+func isOperator(ch byte) bool {
+	switch ch {
+	case '=', '!', '<', '>', '&', '|', '+', '-', '*', '/', '%', ':', '?':
+		return true
+	}
+	return false
+}
+
 func isSpace(ch byte) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
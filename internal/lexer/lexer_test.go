@@ -131,12 +131,188 @@ Items:
 				{Type: TokenEOF},
 			},
 		},
+		{
+			name:  "If with boolean literal",
+			input: "{{if true}}yes{{else}}no{{end}}",
+			expected: []Token{
+				{TokenLDelim, "{{"},
+				{TokenIdentifier, "if"},
+				{TokenSpace, " "},
+				{TokenLiteralBoolean, "true"},
+				{TokenRDelim, "}}"},
+				{TokenLiteralString, "yes"},
+				{TokenLDelim, "{{"},
+				{TokenIdentifier, "else"},
+				{TokenRDelim, "}}"},
+				{TokenLiteralString, "no"},
+				{TokenLDelim, "{{"},
+				{TokenIdentifier, "end"},
+				{TokenRDelim, "}}"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Nil literal",
+			input: "{{.a==nil}}{{nilable}}",
+			expected: []Token{
+				{TokenLDelim, "{{"},
+				{TokenAccessor, ".a"},
+				{TokenOperator, "=="},
+				{TokenLiteralNil, "nil"},
+				{TokenRDelim, "}}"},
+				{TokenLDelim, "{{"},
+				{TokenIdentifier, "nilable"},
+				{TokenRDelim, "}}"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Coalesce operator",
+			input: "{{.a??.b ?? 'x'}}",
+			expected: []Token{
+				{TokenLDelim, "{{"},
+				{TokenAccessor, ".a"},
+				{TokenOperator, "??"},
+				{TokenAccessor, ".b"},
+				{TokenSpace, " "},
+				{TokenOperator, "??"},
+				{TokenSpace, " "},
+				{TokenLiteralString, "x"},
+				{TokenRDelim, "}}"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Comparison and boolean operators",
+			input: "{{if .total>100 && !.vip || .a != .b}}",
+			expected: []Token{
+				{TokenLDelim, "{{"},
+				{TokenIdentifier, "if"},
+				{TokenSpace, " "},
+				{TokenAccessor, ".total"},
+				{TokenOperator, ">"},
+				{TokenLiteralNumber, "100"},
+				{TokenSpace, " "},
+				{TokenOperator, "&&"},
+				{TokenSpace, " "},
+				{TokenOperator, "!"},
+				{TokenAccessor, ".vip"},
+				{TokenSpace, " "},
+				{TokenOperator, "||"},
+				{TokenSpace, " "},
+				{TokenAccessor, ".a"},
+				{TokenSpace, " "},
+				{TokenOperator, "!="},
+				{TokenSpace, " "},
+				{TokenAccessor, ".b"},
+				{TokenRDelim, "}}"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Ordering operators",
+			input: "{{.a<=.b>=.c<.d}}",
+			expected: []Token{
+				{TokenLDelim, "{{"},
+				{TokenAccessor, ".a"},
+				{TokenOperator, "<="},
+				{TokenAccessor, ".b"},
+				{TokenOperator, ">="},
+				{TokenAccessor, ".c"},
+				{TokenOperator, "<"},
+				{TokenAccessor, ".d"},
+				{TokenRDelim, "}}"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Arithmetic operators",
+			input: "{{.qty*.price+1.25-2/3%4}}",
+			expected: []Token{
+				{TokenLDelim, "{{"},
+				{TokenAccessor, ".qty"},
+				{TokenOperator, "*"},
+				{TokenAccessor, ".price"},
+				{TokenOperator, "+"},
+				{TokenLiteralNumber, "1.25"},
+				{TokenOperator, "-"},
+				{TokenLiteralNumber, "2"},
+				{TokenOperator, "/"},
+				{TokenLiteralNumber, "3"},
+				{TokenOperator, "%"},
+				{TokenLiteralNumber, "4"},
+				{TokenRDelim, "}}"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Pipeline",
+			input: "{{ .name | lower|upper }}",
+			expected: []Token{
+				{TokenLDelim, "{{"},
+				{TokenSpace, " "},
+				{TokenAccessor, ".name"},
+				{TokenSpace, " "},
+				{TokenOperator, "|"},
+				{TokenSpace, " "},
+				{TokenIdentifier, "lower"},
+				{TokenOperator, "|"},
+				{TokenIdentifier, "upper"},
+				{TokenSpace, " "},
+				{TokenRDelim, "}}"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Variable declaration and assignment",
+			input: "{{$user := .user}}{{$user.name = $}}",
+			expected: []Token{
+				{TokenLDelim, "{{"},
+				{TokenVariable, "$user"},
+				{TokenSpace, " "},
+				{TokenOperator, ":="},
+				{TokenSpace, " "},
+				{TokenAccessor, ".user"},
+				{TokenRDelim, "}}"},
+				{TokenLDelim, "{{"},
+				{TokenVariable, "$user.name"},
+				{TokenSpace, " "},
+				{TokenOperator, "="},
+				{TokenSpace, " "},
+				{TokenVariable, "$"},
+				{TokenRDelim, "}}"},
+				{Type: TokenEOF},
+			},
+		},
+		{
+			name:  "Index expressions",
+			input: `{{.rows[0].name}}{{$m["content-type"]}}`,
+			expected: []Token{
+				{TokenLDelim, "{{"},
+				{TokenAccessor, ".rows"},
+				{TokenLBracket, "["},
+				{TokenLiteralNumber, "0"},
+				{TokenRBracket, "]"},
+				{TokenAccessor, ".name"},
+				{TokenRDelim, "}}"},
+				{TokenLDelim, "{{"},
+				{TokenVariable, "$m"},
+				{TokenLBracket, "["},
+				{TokenLiteralString, "content-type"},
+				{TokenRBracket, "]"},
+				{TokenRDelim, "}}"},
+				{Type: TokenEOF},
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			lexer := NewLexer(tt.input)
-			tokens := lexer.Lex()
+			tokens, err := lexer.Lex()
+			if err != nil {
+				t.Fatalf("Lex() error = %v", err)
+			}
 
 			if !reflect.DeepEqual(tokens, tt.expected) {
 				t.Errorf("\nExpected tokens %v\nGot             %v", tt.expected, tokens)
@@ -145,6 +321,34 @@ Items:
 	}
 }
 
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "unknown character", input: "{{ .a # .b }}", expected: "line 1: unexpected character '#'"},
+		{name: "lone ampersand", input: "{{ .a & .b }}", expected: "line 1: unexpected character '&'"},
+		{name: "lone question mark", input: "{{ .a ? .b }}", expected: "line 1: unexpected character '?'"},
+		{name: "unterminated string", input: "a\n{{ upper(\"abc) }}", expected: "line 2: unterminated string"},
+		{name: "error on later line", input: "a\nb\n{{ .c\n ~ }}", expected: "line 4: unexpected character '~'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lexer := NewLexer(tt.input)
+			defer lexer.Release()
+			tokens, err := lexer.Lex()
+			if err == nil {
+				t.Fatalf("Lex() = %v, want error", tokens)
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("Lex() error = %q, want %q", err, tt.expected)
+			}
+		})
+	}
+}
+
 func BenchmarkLexer(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		lexer := NewLexer("{{range .items}}{{.}}{{end}}")
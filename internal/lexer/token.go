@@ -17,6 +17,11 @@ const (
 	TokenAccessor
 	TokenComma
 	TokenRDelim
+	TokenOperator
+	TokenVariable
+	TokenLBracket
+	TokenRBracket
+	TokenLiteralNil
 )
 
 func (t TokenType) toString() string {
@@ -45,6 +50,16 @@ func (t TokenType) toString() string {
 		return "Comma"
 	case TokenRDelim:
 		return "RDelim"
+	case TokenOperator:
+		return "Operator"
+	case TokenVariable:
+		return "Variable"
+	case TokenLBracket:
+		return "LBracket"
+	case TokenRBracket:
+		return "RBracket"
+	case TokenLiteralNil:
+		return "LiteralNil"
 	default:
 		return "Unknown"
 	}
@@ -0,0 +1,85 @@
+package values
+
+import (
+	"cmp"
+	"math"
+	"reflect"
+)
+
+// Kind orders the numeric types arithmetic promotes between: an int combined
+// with an int64 yields an int64, and anything combined with a float yields a
+// float64.
+type Kind int
+
+const (
+	KindInt Kind = iota
+	KindInt64
+	KindFloat
+)
+
+// Number is a numeric value normalised to either an int64 or a float64. Kind
+// records whether it was an int, another integer or a float.
+type Number struct {
+	I    int64
+	F    float64
+	Kind Kind
+}
+
+func (n Number) Float() float64 {
+	if n.Kind == KindFloat {
+		return n.F
+	}
+	return float64(n.I)
+}
+
+// Value returns n as an int, an int64 or a float64, according to its kind.
+func (n Number) Value() interface{} {
+	switch n.Kind {
+	case KindInt:
+		return int(n.I)
+	case KindInt64:
+		return n.I
+	default:
+		return n.F
+	}
+}
+
+// ToNumber converts any Go integer or float kind to a Number. Integers other
+// than int are treated as int64, unsigned ones too large for it as float64,
+// and float32 as float64.
+func ToNumber(value interface{}) (Number, bool) {
+	switch v := value.(type) {
+	case int:
+		return Number{I: int64(v), Kind: KindInt}, true
+	case int64:
+		return Number{I: v, Kind: KindInt64}, true
+	case float64:
+		return Number{F: v, Kind: KindFloat}, true
+	case nil, string, bool:
+		return Number{}, false
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Number{I: rv.Int(), Kind: KindInt64}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return Number{F: float64(u), Kind: KindFloat}, true
+		}
+		return Number{I: int64(u), Kind: KindInt64}, true
+	case reflect.Float32, reflect.Float64:
+		return Number{F: rv.Float(), Kind: KindFloat}, true
+	}
+	return Number{}, false
+}
+
+// CompareNumbers orders two numbers, comparing integers exactly and anything
+// involving a float as float64s.
+func CompareNumbers(a, b Number) int {
+	if a.Kind == KindFloat || b.Kind == KindFloat {
+		return cmp.Compare(a.Float(), b.Float())
+	}
+	return cmp.Compare(a.I, b.I)
+}
@@ -0,0 +1,77 @@
+// Package values holds what the VM and the template functions must agree on
+// about values: how they are printed, what counts as nil and how numbers of
+// different kinds convert and compare.
+package values
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// IsNil reports whether value is nil or a nil pointer, interface, map, slice,
+// func or channel.
+func IsNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// Append appends the text of value as a template prints it to buf. nil,
+// which is what a missing key resolves to, appends nothing, and so do nil
+// pointers, maps and slices. Numbers of every kind are written in plain
+// decimal notation with no exponent and as few digits as needed, so 1e6 is
+// written as 1000000 and a float32 0.1 as 0.1. Values that implement
+// fmt.Stringer are written with their String method.
+func Append(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return buf
+	case string:
+		return append(buf, v...)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case float64:
+		return strconv.AppendFloat(buf, v, 'f', -1, 64)
+	case float32:
+		return strconv.AppendFloat(buf, float64(v), 'f', -1, 32)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	}
+
+	if IsNil(value) {
+		return buf
+	}
+	if s, ok := value.(fmt.Stringer); ok {
+		return append(buf, s.String()...)
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(buf, rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(buf, rv.Uint(), 10)
+	case reflect.Float32:
+		return strconv.AppendFloat(buf, rv.Float(), 'f', -1, 32)
+	case reflect.Float64:
+		return strconv.AppendFloat(buf, rv.Float(), 'f', -1, 64)
+	default:
+		return fmt.Appendf(buf, "%v", value)
+	}
+}
+
+// String returns the text of value as a template prints it.
+func String(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return string(Append(nil, value))
+}
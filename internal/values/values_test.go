@@ -0,0 +1,105 @@
+package values
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testCents int
+
+type testMoney int
+
+func (m testMoney) String() string { return fmt.Sprintf("$%d.%02d", m/100, m%100) }
+
+type testStringer struct{ name string }
+
+func (s *testStringer) String() string { return s.name }
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"string", "a", "a"},
+		{"int", -3, "-3"},
+		{"int64", int64(1) << 40, "1099511627776"},
+		{"float", 2.50, "2.5"},
+		{"large float", 1e21, "1000000000000000000000"},
+		{"small float", 0.000001, "0.000001"},
+		{"float32", float32(0.1), "0.1"},
+		{"uint8", uint8(200), "200"},
+		{"uint64", uint64(1) << 63, "9223372036854775808"},
+		{"int32", int32(-7), "-7"},
+		{"named int", testCents(42), "42"},
+		{"stringer", testMoney(1234), "$12.34"},
+		{"bool", true, "true"},
+		{"slice", []int{1, 2}, "[1 2]"},
+		{"nil", nil, ""},
+		{"nil pointer", (*string)(nil), ""},
+		{"nil stringer", (*testStringer)(nil), ""},
+		{"nil map", map[string]int(nil), ""},
+		{"nil slice", []string(nil), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Append([]byte("x"), tt.value)); got != "x"+tt.expected {
+				t.Errorf("Append(%#v) = %q, want %q", tt.value, got, "x"+tt.expected)
+			}
+		})
+	}
+}
+
+func TestToNumber(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected Number
+		ok       bool
+	}{
+		{"int", 3, Number{I: 3, Kind: KindInt}, true},
+		{"int64", int64(-4), Number{I: -4, Kind: KindInt64}, true},
+		{"int8", int8(5), Number{I: 5, Kind: KindInt64}, true},
+		{"uint", uint(6), Number{I: 6, Kind: KindInt64}, true},
+		{"huge uint64", uint64(1) << 63, Number{F: 1 << 63, Kind: KindFloat}, true},
+		{"float32", float32(0.5), Number{F: 0.5, Kind: KindFloat}, true},
+		{"named int", testCents(7), Number{I: 7, Kind: KindInt64}, true},
+		{"numeric string", "1", Number{}, false},
+		{"nil", nil, Number{}, false},
+		{"bool", true, Number{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ToNumber(tt.value)
+			if ok != tt.ok || got != tt.expected {
+				t.Errorf("ToNumber(%#v) = %+v, %v, want %+v, %v", tt.value, got, ok, tt.expected, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCompareNumbers(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     interface{}
+		expected int
+	}{
+		{"ints", 1, 2, -1},
+		{"int and int64", int64(3), 3, 0},
+		{"large ints compare exactly", int64(1<<62 + 1), int64(1 << 62), 1},
+		{"int and float", 2, 1.5, 1},
+		{"uint8 and float32", uint8(1), float32(1), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := ToNumber(tt.a)
+			b, _ := ToNumber(tt.b)
+			if got := CompareNumbers(a, b); got != tt.expected {
+				t.Errorf("CompareNumbers(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package vm
+
+import (
+	"fmt"
+	"iter"
+	"reflect"
+	"slices"
+
+	"github.com/flothq/swap/internal/access"
+	"github.com/flothq/swap/pkg/bytecode"
+)
+
+type loopKind int
+
+const (
+	loopItems loopKind = iota
+	loopStrings
+	loopObjects
+	loopReflect
+	loopStream
+)
+
+// loopInfo is the state of one running range loop. The common slice types
+// are iterated in place, other slices and arrays through reflection. Maps are
+// snapshotted into their keys and values, ordered by key so that output does
+// not depend on Go's map iteration order. Channels and iterators are pulled
+// one element ahead so the loop knows when it is on the last element; their
+// length is unknown and reported as -1.
+type loopInfo struct {
+	kind       loopKind
+	items      []interface{}
+	strings    []string
+	objects    []map[string]interface{}
+	list       reflect.Value
+	stringKeys []string
+	keys       []interface{}
+
+	next       func() (interface{}, interface{}, bool)
+	stop       func()
+	currentKey interface{}
+	current    interface{}
+	peekKey    interface{}
+	peek       interface{}
+	hasPeek    bool
+
+	length  int
+	index   int
+	startPC int
+}
+
+func (l *loopInfo) value() interface{} {
+	switch l.kind {
+	case loopStrings:
+		return l.strings[l.index]
+	case loopObjects:
+		return l.objects[l.index]
+	case loopReflect:
+		return l.list.Index(l.index).Interface()
+	case loopStream:
+		return l.current
+	default:
+		return l.items[l.index]
+	}
+}
+
+func (l *loopInfo) key() interface{} {
+	switch {
+	case l.kind == loopStream:
+		return l.currentKey
+	case l.stringKeys != nil:
+		return l.stringKeys[l.index]
+	case l.keys != nil:
+		return l.keys[l.index]
+	default:
+		return l.index
+	}
+}
+
+func (l *loopInfo) isLast() bool {
+	if l.kind == loopStream {
+		return !l.hasPeek
+	}
+	return l.index == l.length-1
+}
+
+// advance moves to the next element and reports whether there is one.
+func (l *loopInfo) advance() bool {
+	l.index++
+	if l.kind != loopStream {
+		return l.index < l.length
+	}
+	if !l.hasPeek {
+		return false
+	}
+	l.currentKey, l.current = l.peekKey, l.peek
+	l.peekKey, l.peek, l.hasPeek = l.next()
+	return true
+}
+
+// close releases the iterator behind a stream, if any.
+func (l *loopInfo) close() {
+	if l.stop != nil {
+		l.stop()
+	}
+	*l = loopInfo{}
+}
+
+// handleLoopStart starts a loop over the value of path constant a, skipping
+// to b when there is nothing to iterate. c is the template line of the path.
+func (vm *VM) handleLoopStart(a, b, c uint16) error {
+	res, err := vm.resolveVar(vm.getConstantPath(a), c, false)
+	if err != nil {
+		return err
+	}
+	return vm.startLoop(res, b)
+}
+
+// startLoop pushes a loop over items whose body starts at the next
+// instruction. When there is nothing to iterate, including a nil value, no
+// loop is pushed and execution continues at skip instead. Values that cannot
+// be iterated, such as strings and numbers, are an error.
+func (vm *VM) startLoop(res interface{}, skip uint16) error {
+	var info loopInfo
+	info.startPC = vm.pc + 1
+	info.index = 0
+
+	switch v := res.(type) {
+	case []interface{}:
+		info.items = v
+		info.length = len(v)
+	case []string:
+		info.kind = loopStrings
+		info.strings = v
+		info.length = len(v)
+	case []map[string]interface{}:
+		info.kind = loopObjects
+		info.objects = v
+		info.length = len(v)
+	case map[string]interface{}:
+		info.stringKeys = make([]string, 0, len(v))
+		for k := range v {
+			info.stringKeys = append(info.stringKeys, k)
+		}
+		slices.Sort(info.stringKeys)
+		info.items = make([]interface{}, len(info.stringKeys))
+		for i, k := range info.stringKeys {
+			info.items[i] = v[k]
+		}
+		info.length = len(v)
+	case iter.Seq[interface{}]:
+		info.setSeq2(func(yield func(interface{}, interface{}) bool) {
+			i := 0
+			for item := range v {
+				if !yield(i, item) {
+					return
+				}
+				i++
+			}
+		})
+	case iter.Seq2[interface{}, interface{}]:
+		info.setSeq2(v)
+	case nil:
+	default:
+		if err := info.setReflect(reflect.ValueOf(res)); err != nil {
+			return err
+		}
+	}
+
+	if info.length == 0 {
+		info.close()
+		vm.pc = int(skip) - 1
+		return nil
+	}
+
+	if len(vm.loopStack) < cap(vm.loopStack) {
+		vm.loopStack = vm.loopStack[:len(vm.loopStack)+1]
+	} else {
+		newCap := cap(vm.loopStack) * 2
+		if newCap == 0 {
+			newCap = 4
+		}
+		newStack := make([]loopInfo, len(vm.loopStack)+1, newCap)
+		copy(newStack, vm.loopStack)
+		vm.loopStack = newStack
+	}
+	vm.loopStack[len(vm.loopStack)-1] = info
+	vm.pushDot(info.value())
+	return nil
+}
+
+func (l *loopInfo) setReflect(rv reflect.Value) error {
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		l.kind = loopReflect
+		l.list = rv
+		l.length = rv.Len()
+	case reflect.Map:
+		l.setMap(rv)
+	case reflect.Chan:
+		if rv.Type().ChanDir()&reflect.RecvDir == 0 {
+			return fmt.Errorf("cannot range over send-only channel %s", rv.Type())
+		}
+		i := 0
+		l.setNext(func() (interface{}, interface{}, bool) {
+			item, ok := rv.Recv()
+			if !ok {
+				return nil, nil, false
+			}
+			i++
+			return i - 1, item.Interface(), true
+		}, nil)
+	case reflect.Func:
+		seq, ok := access.Seq(rv)
+		if !ok {
+			return fmt.Errorf("cannot range over %s", rv.Type())
+		}
+		l.setSeq2(seq)
+	default:
+		return fmt.Errorf("cannot range over %s", rv.Type())
+	}
+	return nil
+}
+
+func (l *loopInfo) setSeq2(seq iter.Seq2[interface{}, interface{}]) {
+	next, stop := iter.Pull2(seq)
+	l.setNext(next, stop)
+}
+
+// setNext starts a stream, reading its first element and the one after it.
+func (l *loopInfo) setNext(next func() (interface{}, interface{}, bool), stop func()) {
+	l.kind = loopStream
+	l.next = next
+	l.stop = stop
+	l.length = -1
+
+	var ok bool
+	l.currentKey, l.current, ok = next()
+	if !ok {
+		l.length = 0
+		return
+	}
+	l.peekKey, l.peek, l.hasPeek = next()
+}
+
+// setMap snapshots an arbitrary map in the key order of access.MapKeys.
+func (l *loopInfo) setMap(rv reflect.Value) {
+	keys := access.MapKeys(rv)
+	l.length = len(keys)
+	l.items = make([]interface{}, len(keys))
+
+	if rv.Type().Key().Kind() == reflect.String {
+		l.stringKeys = make([]string, len(keys))
+		for i, k := range keys {
+			l.stringKeys[i] = k.String()
+			l.items[i] = rv.MapIndex(k).Interface()
+		}
+		return
+	}
+
+	l.keys = make([]interface{}, len(keys))
+	for i, k := range keys {
+		l.keys[i] = k.Interface()
+		l.items[i] = rv.MapIndex(k).Interface()
+	}
+}
+
+func (vm *VM) handleLoopEnd() {
+	if len(vm.loopStack) > 0 {
+		last := &vm.loopStack[len(vm.loopStack)-1]
+		if last.advance() {
+			vm.dots[len(vm.dots)-1] = last.value()
+			vm.pc = last.startPC - 1
+			return
+		} else {
+			last.close()
+			vm.loopStack = vm.loopStack[:len(vm.loopStack)-1]
+			vm.popDot()
+		}
+	}
+}
+
+// handleLoopBreak pops the innermost loop without finishing it.
+func (vm *VM) handleLoopBreak() {
+	if len(vm.loopStack) > 0 {
+		vm.loopStack[len(vm.loopStack)-1].close()
+		vm.loopStack = vm.loopStack[:len(vm.loopStack)-1]
+		vm.popDot()
+	}
+}
+
+// loopMeta returns the current key, value or position of the innermost loop.
+func (vm *VM) loopMeta(field bytecode.LoopField) interface{} {
+	if len(vm.loopStack) == 0 {
+		return nil
+	}
+	last := &vm.loopStack[len(vm.loopStack)-1]
+	switch field {
+	case bytecode.LoopKey:
+		return last.key()
+	case bytecode.LoopValue:
+		return last.value()
+	case bytecode.LoopIndex0:
+		return last.index
+	case bytecode.LoopIndex:
+		return last.index + 1
+	case bytecode.LoopFirst:
+		return last.index == 0
+	case bytecode.LoopLast:
+		return last.isLast()
+	case bytecode.LoopLength:
+		return last.length
+	default:
+		return nil
+	}
+}
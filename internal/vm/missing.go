@@ -0,0 +1,97 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/flothq/swap/internal/access"
+	"github.com/flothq/swap/pkg/bytecode"
+)
+
+// MissingKeyPolicy selects what happens when a template refers to a key the
+// context does not have. The left side of ?? and the first argument of
+// default may always be missing.
+type MissingKeyPolicy uint8
+
+const (
+	// MissingKeyDefault leaves the choice to the engine, which renders
+	// missing keys as empty unless configured otherwise.
+	MissingKeyDefault MissingKeyPolicy = iota
+	// MissingKeyEmpty renders missing keys as empty, like nil.
+	MissingKeyEmpty
+	// MissingKeyPlaceholder prints a missing key as [missing .path] when
+	// an action prints a path, field or index directly. Missing keys used in
+	// expressions, such as function arguments, are still nil.
+	MissingKeyPlaceholder
+	// MissingKeyFail stops the render with a *MissingKeyError.
+	MissingKeyFail
+)
+
+func (p MissingKeyPolicy) String() string {
+	switch p {
+	case MissingKeyDefault:
+		return "default"
+	case MissingKeyEmpty:
+		return "empty"
+	case MissingKeyPlaceholder:
+		return "placeholder"
+	case MissingKeyFail:
+		return "fail"
+	default:
+		return fmt.Sprintf("MissingKeyPolicy(%d)", uint8(p))
+	}
+}
+
+// MissingKeyError reports a key the context does not have, under the
+// MissingKeyFail policy.
+type MissingKeyError struct {
+	// Path is the full path of the missing key, such as ".user.name".
+	Path string
+	// Line is the template line of the key, counting from 1.
+	Line int
+}
+
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("line %d: missing key %s", e.Line, e.Path)
+}
+
+// SetMissingKey sets the policy for missing keys. MissingKeyDefault renders
+// them as empty.
+func (vm *VM) SetMissingKey(policy MissingKeyPolicy) {
+	vm.missingKey = policy
+}
+
+// isMissing reports whether path resolves to nil because a key along it is
+// missing rather than because its value is nil. It looks in the same place
+// as resolveVar.
+func (vm *VM) isMissing(path bytecode.Path) bool {
+	base, segments := vm.context, path.Segments
+	if !path.Root && len(vm.dots) > 0 {
+		if len(segments) == 0 {
+			return false
+		}
+		dot := vm.dots[len(vm.dots)-1]
+		if _, ok := access.Lookup(dot, segments[0]); ok {
+			base = dot
+		}
+	}
+	return !access.Exists(base, segments)
+}
+
+// missingLookup applies the policy to a field or index lookup that found a
+// missing key. name is the constant holding the text of the lookup, such as
+// $user.name or .items[0], and line its template line. Under
+// MissingKeyPlaceholder it records the name for an OpPrint that follows.
+func (vm *VM) missingLookup(name, line uint16) error {
+	switch vm.missingKey {
+	case MissingKeyFail:
+		return &MissingKeyError{Path: vm.getConstantString(name), Line: int(line)}
+	case MissingKeyPlaceholder:
+		vm.missed = vm.getConstantString(name)
+	}
+	return nil
+}
+
+// placeholder returns what MissingKeyPlaceholder prints for a missing path.
+func placeholder(path string) string {
+	return "[missing " + path + "]"
+}
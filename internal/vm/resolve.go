@@ -0,0 +1,117 @@
+package vm
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/flothq/swap/internal/access"
+	"github.com/flothq/swap/internal/values"
+)
+
+// index returns container[key]. Slices and arrays take an integer,
+// counted from the end when negative, and report an error when it is out of
+// range. Maps take a key of their key type and yield nil when it is missing;
+// structs take a field name. Indexing nil yields nil.
+func index(container, key interface{}) (interface{}, error) {
+	switch c := container.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		if k, ok := key.(string); ok {
+			return c[k], nil
+		}
+	case []interface{}:
+		if i, ok := key.(int); ok && i >= 0 && i < len(c) {
+			return c[i], nil
+		}
+	}
+
+	rv := reflect.ValueOf(container)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		n, ok := values.ToNumber(key)
+		if !ok || n.Kind == values.KindFloat {
+			return nil, fmt.Errorf("cannot index %s with %T", rv.Type(), key)
+		}
+		i := n.I
+		if i < 0 {
+			i += int64(rv.Len())
+		}
+		if i < 0 || i >= int64(rv.Len()) {
+			return nil, fmt.Errorf("index %d out of range for %s of length %d", n.I, rv.Type(), rv.Len())
+		}
+		return rv.Index(int(i)).Interface(), nil
+	case reflect.Map:
+		k, err := mapKey(rv.Type().Key(), key)
+		if err != nil {
+			return nil, err
+		}
+		entry := rv.MapIndex(k)
+		if !entry.IsValid() {
+			return nil, nil
+		}
+		return entry.Interface(), nil
+	case reflect.Struct:
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot index %s with %T", rv.Type(), key)
+		}
+		value, _ := access.LookupValue(rv, name)
+		return value, nil
+	default:
+		return nil, fmt.Errorf("cannot index %s", rv.Type())
+	}
+}
+
+// hasIndex reports whether container has an entry or field at key, to tell
+// a missing key from a nil value. Indexes into slices and arrays always
+// exist since index rejects the others.
+func hasIndex(container, key interface{}) bool {
+	if name, ok := key.(string); ok {
+		return access.Exists(container, []string{name})
+	}
+	rv := reflect.ValueOf(container)
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return false
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		return true
+	case reflect.Map:
+		k, err := mapKey(rv.Type().Key(), key)
+		return err == nil && rv.MapIndex(k).IsValid()
+	}
+	return false
+}
+
+// mapKey converts key to a map's key type. Numbers convert between numeric
+// key types and strings between string types, but a number never becomes a
+// string key or the other way round.
+func mapKey(keyType reflect.Type, key interface{}) (reflect.Value, error) {
+	if key == nil {
+		return reflect.Zero(keyType), nil
+	}
+	k := reflect.ValueOf(key)
+	if k.Type().AssignableTo(keyType) {
+		return k, nil
+	}
+	if isNumericKind(k.Kind()) && isNumericKind(keyType.Kind()) ||
+		k.Kind() == reflect.String && keyType.Kind() == reflect.String {
+		return k.Convert(keyType), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %T as %s map key", key, keyType)
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
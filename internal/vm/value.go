@@ -0,0 +1,177 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/flothq/swap/internal/values"
+)
+
+// isTruthy reports whether a value counts as true in a condition. nil, false,
+// zero numbers and empty strings, slices, arrays and maps are false; every
+// other value is true.
+func isTruthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case int:
+		return v != 0
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case []string:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() != 0
+	case reflect.Complex64, reflect.Complex128:
+		return rv.Complex() != 0
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map, reflect.Chan:
+		return rv.Len() > 0
+	case reflect.Pointer, reflect.Interface, reflect.Func:
+		return !rv.IsNil()
+	default:
+		return true
+	}
+}
+
+// arithmetic applies one of + - * / % to two values. + also concatenates two
+// strings. Integer operands stay integers, so / truncates like it does in Go.
+func arithmetic(op byte, a, b interface{}) (interface{}, error) {
+	if op == '+' {
+		if as, ok := a.(string); ok {
+			if bs, ok := b.(string); ok {
+				return as + bs, nil
+			}
+		}
+	}
+
+	na, okA := values.ToNumber(a)
+	nb, okB := values.ToNumber(b)
+	if !okA || !okB {
+		return nil, fmt.Errorf("invalid operation: %T %c %T", a, op, b)
+	}
+
+	kind := max(na.Kind, nb.Kind)
+	if kind == values.KindFloat {
+		x, y := na.Float(), nb.Float()
+		var f float64
+		switch op {
+		case '+':
+			f = x + y
+		case '-':
+			f = x - y
+		case '*':
+			f = x * y
+		case '/', '%':
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == '/' {
+				f = x / y
+			} else {
+				f = math.Mod(x, y)
+			}
+		}
+		return values.Number{F: f, Kind: kind}.Value(), nil
+	}
+
+	x, y := na.I, nb.I
+	var i int64
+	switch op {
+	case '+':
+		i = x + y
+	case '-':
+		i = x - y
+	case '*':
+		i = x * y
+	case '/', '%':
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if op == '/' {
+			i = x / y
+		} else {
+			i = x % y
+		}
+	}
+	return values.Number{I: i, Kind: kind}.Value(), nil
+}
+
+func negate(value interface{}) (interface{}, error) {
+	n, ok := values.ToNumber(value)
+	if !ok {
+		return nil, fmt.Errorf("invalid operation: -%T", value)
+	}
+	n.I, n.F = -n.I, -n.F
+	return n.Value(), nil
+}
+
+// valuesEqual compares two values for ==. Numbers of different kinds are
+// compared by value, values of different types are never equal and nil is
+// only equal to nil.
+func valuesEqual(a, b interface{}) (bool, error) {
+	if na, ok := values.ToNumber(a); ok {
+		if nb, ok := values.ToNumber(b); ok {
+			return values.CompareNumbers(na, nb) == 0, nil
+		}
+		return false, nil
+	}
+
+	switch av := a.(type) {
+	case string:
+		bv, ok := b.(string)
+		return ok && av == bv, nil
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv, nil
+	}
+
+	if values.IsNil(a) || values.IsNil(b) {
+		return values.IsNil(a) && values.IsNil(b), nil
+	}
+
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) {
+		return false, nil
+	}
+	if !ta.Comparable() {
+		return false, fmt.Errorf("cannot compare values of type %T", a)
+	}
+	return a == b, nil
+}
+
+// compareValues orders two numbers or two strings, returning -1, 0 or 1.
+func compareValues(a, b interface{}) (int, error) {
+	if na, ok := values.ToNumber(a); ok {
+		if nb, ok := values.ToNumber(b); ok {
+			return values.CompareNumbers(na, nb), nil
+		}
+	}
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return strings.Compare(as, bs), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T and %T", a, b)
+}
@@ -3,30 +3,27 @@ package vm
 import (
 	"bytes"
 	"fmt"
-	"strconv"
-	"strings"
 	"sync"
-	"time"
-	"unsafe"
 
+	"github.com/flothq/swap/internal/access"
+	"github.com/flothq/swap/internal/funcs"
+	"github.com/flothq/swap/internal/values"
 	"github.com/flothq/swap/pkg/bytecode"
 )
 
-type loopInfo struct {
-	items   unsafe.Pointer
-	itemLen int
-	itemCap int
-	index   int
-	startPC int
-}
-
 type Program struct {
 	Instructions []bytecode.Instruction
 	Constants    []bytecode.Constant
+	// Funcs is the function table the program was compiled against. Its
+	// calls are indexes into it, so it only runs with the same table.
+	Funcs *funcs.Table
+	// MissingKey overrides the engine's missing-key policy for this
+	// program unless it is MissingKeyDefault. It is not serialized.
+	MissingKey MissingKeyPolicy
 }
 
-func NewProgram(instructions []bytecode.Instruction, constants []bytecode.Constant) *Program {
-	return &Program{Instructions: instructions, Constants: constants}
+func NewProgram(instructions []bytecode.Instruction, constants []bytecode.Constant, table *funcs.Table) *Program {
+	return &Program{Instructions: instructions, Constants: constants, Funcs: table}
 }
 
 func (p *Program) Serialize() ([]byte, error) {
@@ -38,13 +35,36 @@ func (p *Program) Serialize() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// FuncError reports a template function that failed while rendering,
+// either by returning an error or by panicking.
+type FuncError struct {
+	// Func is the name the template called the function by.
+	Func string
+	// Line is the template line of the call, counting from 1.
+	Line int
+	Err  error
+}
+
+func (e *FuncError) Error() string {
+	return fmt.Sprintf("line %d: call to %s: %v", e.Line, e.Func, e.Err)
+}
+
+func (e *FuncError) Unwrap() error {
+	return e.Err
+}
+
 type VM struct {
 	instructions []bytecode.Instruction
-	registers    []unsafe.Pointer
-	context      map[string]interface{}
+	context      interface{}
 	constants    []bytecode.Constant
 	buffer       []byte
 	loopStack    []loopInfo
+	dots         []interface{}
+	stack        []interface{}
+	vars         []interface{}
+	funcs        *funcs.Table
+	missingKey   MissingKeyPolicy
+	missed       string
 	pc           int
 	unpacked     bytecode.UnpackedInstruction
 }
@@ -53,13 +73,15 @@ var vmPool = sync.Pool{
 	New: func() interface{} {
 		return &VM{
 			loopStack: make([]loopInfo, 0, 4),
+			stack:     make([]interface{}, 0, 8),
 			unpacked:  bytecode.UnpackedInstruction{},
-			registers: make([]unsafe.Pointer, 8),
 		}
 	},
 }
 
-func NewVM(instructions []bytecode.Instruction, context map[string]interface{}, constants []bytecode.Constant) *VM {
+// NewVM returns a VM that runs instructions against context, which may be a
+// map, a struct or a pointer to either.
+func NewVM(instructions []bytecode.Instruction, context interface{}, constants []bytecode.Constant) *VM {
 	vm := vmPool.Get().(*VM)
 	vm.instructions = instructions
 	vm.context = context
@@ -69,22 +91,39 @@ func NewVM(instructions []bytecode.Instruction, context map[string]interface{},
 		vm.buffer = vm.buffer[:0]
 	}
 	vm.loopStack = vm.loopStack[:0]
+	vm.dots = vm.dots[:0]
+	vm.stack = vm.stack[:0]
+	vm.vars = vm.vars[:0]
 	vm.constants = constants
+	vm.funcs = funcs.Default()
+	vm.missingKey = MissingKeyDefault
+	vm.missed = ""
 	vm.unpacked.Reset()
 	vm.pc = 0
-	for i := range vm.registers {
-		vm.registers[i] = nil
-	}
 
 	return vm
 }
 
+// SetFuncs sets the function table the program was compiled against.
+func (vm *VM) SetFuncs(t *funcs.Table) {
+	vm.funcs = t
+}
+
 func (vm *VM) Release() {
 	vm.instructions = nil
+	vm.funcs = nil
 	vm.context = nil
 	vm.buffer = vm.buffer[:0]
+	for i := range vm.loopStack {
+		vm.loopStack[i].close()
+	}
 	vm.loopStack = vm.loopStack[:0]
-	vm.registers = vm.registers[:0]
+	clear(vm.dots)
+	vm.dots = vm.dots[:0]
+	clear(vm.stack)
+	vm.stack = vm.stack[:0]
+	clear(vm.vars)
+	vm.vars = vm.vars[:0]
 	vm.constants = vm.constants[:0]
 	vm.pc = 0
 	vm.unpacked.Reset()
@@ -92,58 +131,6 @@ func (vm *VM) Release() {
 	vmPool.Put(vm)
 }
 
-func (vm *VM) handleLoopStart(a, b, c uint8) {
-	key := vm.constants[a].Value.(string)
-	res := vm.resolveVar(key)
-
-	if res == nil {
-		panic(fmt.Sprintf("loop key not found: %s", key))
-	}
-
-	var info loopInfo
-	info.startPC = vm.pc + 1
-	info.index = 0
-
-	switch v := res.(type) {
-	case []interface{}:
-		info.items = unsafe.Pointer(&v)
-		info.itemLen = len(v)
-		info.itemCap = cap(v)
-	case []string:
-		info.items = unsafe.Pointer(&v)
-		info.itemLen = len(v)
-		info.itemCap = cap(v)
-	default:
-		panic(fmt.Sprintf("unsupported type: %T", v))
-	}
-
-	if len(vm.loopStack) < cap(vm.loopStack) {
-		vm.loopStack = vm.loopStack[:len(vm.loopStack)+1]
-	} else {
-		newCap := cap(vm.loopStack) * 2
-		if newCap == 0 {
-			newCap = 4
-		}
-		newStack := make([]loopInfo, len(vm.loopStack)+1, newCap)
-		copy(newStack, vm.loopStack)
-		vm.loopStack = newStack
-	}
-	vm.loopStack[len(vm.loopStack)-1] = info
-}
-
-func (vm *VM) handleLoopEnd() {
-	if len(vm.loopStack) > 0 {
-		last := &vm.loopStack[len(vm.loopStack)-1]
-		last.index++
-		if last.index < last.itemLen {
-			vm.pc = last.startPC - 1
-			return
-		} else {
-			vm.loopStack = vm.loopStack[:len(vm.loopStack)-1]
-		}
-	}
-}
-
 func (vm *VM) Run() ([]byte, error) {
 	for vm.pc < len(vm.instructions) {
 		instruction := vm.instructions[vm.pc]
@@ -153,17 +140,123 @@ func (vm *VM) Run() ([]byte, error) {
 		case bytecode.OpPrintConst:
 			vm.appendConstantToBuffer(vm.unpacked.A)
 		case bytecode.OpResolvePrint:
-			vm.resolveAndWriteVar(vm.getConstantString(vm.unpacked.A))
-		case bytecode.OpLoadConst:
-			vm.loadConstantToRegister(vm.unpacked.A, vm.unpacked.B)
-		case bytecode.OpResolveLoad:
-			vm.resolveAndLoadToRegister(vm.unpacked.A, vm.unpacked.B)
+			if err := vm.resolveAndWriteVar(vm.getConstantPath(vm.unpacked.A), vm.unpacked.B); err != nil {
+				return nil, err
+			}
 		case bytecode.OpLoopStart:
-			vm.handleLoopStart(vm.unpacked.A, vm.unpacked.B, vm.unpacked.C)
+			if err := vm.handleLoopStart(vm.unpacked.A, vm.unpacked.B, vm.unpacked.C); err != nil {
+				return nil, err
+			}
+		case bytecode.OpLoopStartValue:
+			if err := vm.startLoop(vm.pop(), vm.unpacked.B); err != nil {
+				return nil, err
+			}
 		case bytecode.OpLoopEnd:
 			vm.handleLoopEnd()
+		case bytecode.OpLoopBreak:
+			vm.handleLoopBreak()
+			vm.pc = int(vm.unpacked.A)
+			continue
 		case bytecode.OpCall:
-			vm.handleFunctionCall(vm.unpacked.A)
+			if err := vm.handleFunctionCall(vm.unpacked.A, vm.unpacked.B, vm.unpacked.C); err != nil {
+				return nil, err
+			}
+		case bytecode.OpPushConst:
+			vm.push(vm.constants[vm.unpacked.A].Value)
+		case bytecode.OpResolvePush:
+			value, err := vm.resolveVar(vm.getConstantPath(vm.unpacked.A), vm.unpacked.B, vm.unpacked.C != 0)
+			if err != nil {
+				return nil, err
+			}
+			vm.push(value)
+		case bytecode.OpPrint:
+			value := vm.pop()
+			if value == nil && vm.unpacked.A != 0 && vm.missed != "" {
+				value = placeholder(vm.missed)
+			}
+			vm.writeValue(value)
+		case bytecode.OpJump:
+			vm.pc = int(vm.unpacked.A)
+			continue
+		case bytecode.OpJumpIfFalse:
+			if !isTruthy(vm.pop()) {
+				vm.pc = int(vm.unpacked.A)
+				continue
+			}
+		case bytecode.OpJumpIfFalseOrPop:
+			if !isTruthy(vm.stack[len(vm.stack)-1]) {
+				vm.pc = int(vm.unpacked.A)
+				continue
+			}
+			vm.pop()
+		case bytecode.OpJumpIfTrueOrPop:
+			if isTruthy(vm.stack[len(vm.stack)-1]) {
+				vm.pc = int(vm.unpacked.A)
+				continue
+			}
+			vm.pop()
+		case bytecode.OpJumpIfNotNilOrPop:
+			if !values.IsNil(vm.stack[len(vm.stack)-1]) {
+				vm.pc = int(vm.unpacked.A)
+				continue
+			}
+			vm.pop()
+		case bytecode.OpNot:
+			vm.push(!isTruthy(vm.pop()))
+		case bytecode.OpEqual, bytecode.OpNotEqual, bytecode.OpLess, bytecode.OpLessEqual, bytecode.OpGreater, bytecode.OpGreaterEqual:
+			if err := vm.compare(vm.unpacked.Op); err != nil {
+				return nil, err
+			}
+		case bytecode.OpAdd, bytecode.OpSubtract, bytecode.OpMultiply, bytecode.OpDivide, bytecode.OpModulo:
+			if err := vm.arithmetic(vm.unpacked.Op); err != nil {
+				return nil, err
+			}
+		case bytecode.OpLoadVar:
+			vm.push(vm.loadVar(vm.unpacked.A))
+		case bytecode.OpStoreVar:
+			vm.storeVar(vm.unpacked.A, vm.pop())
+		case bytecode.OpField:
+			vm.missed = ""
+			container := vm.pop()
+			segments := vm.getConstantPath(vm.unpacked.A).Segments
+			value := access.Path(container, segments)
+			if value == nil && vm.unpacked.C != 0 && vm.missingKey > MissingKeyEmpty && !access.Exists(container, segments) {
+				if err := vm.missingLookup(vm.unpacked.C, vm.unpacked.B); err != nil {
+					return nil, err
+				}
+			}
+			vm.push(value)
+		case bytecode.OpIndex:
+			vm.missed = ""
+			key := vm.pop()
+			container := vm.pop()
+			result, err := index(container, key)
+			if err != nil {
+				return nil, err
+			}
+			if result == nil && vm.unpacked.C == 0 && vm.missingKey > MissingKeyEmpty && !hasIndex(container, key) {
+				if err := vm.missingLookup(vm.unpacked.A, vm.unpacked.B); err != nil {
+					return nil, err
+				}
+			}
+			vm.push(result)
+		case bytecode.OpWithStart:
+			value := vm.pop()
+			if !isTruthy(value) {
+				vm.pc = int(vm.unpacked.A)
+				continue
+			}
+			vm.pushDot(value)
+		case bytecode.OpWithEnd:
+			vm.popDot()
+		case bytecode.OpLoopMeta:
+			vm.push(vm.loopMeta(bytecode.LoopField(vm.unpacked.A)))
+		case bytecode.OpNegate:
+			result, err := negate(vm.pop())
+			if err != nil {
+				return nil, err
+			}
+			vm.push(result)
 		case bytecode.OpHalt:
 			return vm.buffer, nil
 		default:
@@ -176,87 +269,181 @@ func (vm *VM) Run() ([]byte, error) {
 	return nil, fmt.Errorf("halt instruction not found")
 }
 
-func (vm *VM) appendConstantToBuffer(index uint8) {
+func (vm *VM) push(value interface{}) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() interface{} {
+	last := len(vm.stack) - 1
+	value := vm.stack[last]
+	vm.stack[last] = nil
+	vm.stack = vm.stack[:last]
+	return value
+}
+
+func (vm *VM) compare(op bytecode.OpCode) error {
+	b := vm.pop()
+	a := vm.pop()
+
+	var result bool
+	switch op {
+	case bytecode.OpEqual, bytecode.OpNotEqual:
+		equal, err := valuesEqual(a, b)
+		if err != nil {
+			return err
+		}
+		result = equal == (op == bytecode.OpEqual)
+	default:
+		order, err := compareValues(a, b)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case bytecode.OpLess:
+			result = order < 0
+		case bytecode.OpLessEqual:
+			result = order <= 0
+		case bytecode.OpGreater:
+			result = order > 0
+		case bytecode.OpGreaterEqual:
+			result = order >= 0
+		}
+	}
+
+	vm.push(result)
+	return nil
+}
+
+var arithmeticOperators = [...]byte{
+	bytecode.OpAdd:      '+',
+	bytecode.OpSubtract: '-',
+	bytecode.OpMultiply: '*',
+	bytecode.OpDivide:   '/',
+	bytecode.OpModulo:   '%',
+}
+
+func (vm *VM) arithmetic(op bytecode.OpCode) error {
+	b := vm.pop()
+	a := vm.pop()
+	result, err := arithmetic(arithmeticOperators[op], a, b)
+	if err != nil {
+		return err
+	}
+	vm.push(result)
+	return nil
+}
+
+func (vm *VM) appendConstantToBuffer(index uint16) {
 	vm.buffer = append(vm.buffer, vm.constants[index].Value.(string)...)
 }
 
-func (vm *VM) getConstantString(index uint8) string {
+func (vm *VM) getConstantString(index uint16) string {
 	return vm.constants[index].Value.(string)
 }
 
-func (vm *VM) loadConstantToRegister(registerIndex, constantIndex uint8) {
-	vm.registers[registerIndex] = unsafe.Pointer(&vm.constants[constantIndex].Value)
+// getConstantPath returns the path constant at index. Strings are accepted as
+// well so hand-assembled programs can keep using plain accessors.
+func (vm *VM) getConstantPath(index uint16) bytecode.Path {
+	switch v := vm.constants[index].Value.(type) {
+	case bytecode.Path:
+		return v
+	case string:
+		return bytecode.ParsePath(v)
+	default:
+		return bytecode.Path{}
+	}
 }
 
-func (vm *VM) resolveAndLoadToRegister(registerIndex, keyIndex uint8) {
-	key := vm.getConstantString(keyIndex)
-	value := vm.resolveVar(key[1:])
-	vm.registers[registerIndex] = unsafe.Pointer(&value)
+// handleFunctionCall calls function fn of the function table with the top
+// argc values on the stack as arguments, the last one on top, and replaces
+// them with the result. line is the template line of the call, reported in
+// the FuncError if the function fails.
+func (vm *VM) handleFunctionCall(fn, line, argc uint16) error {
+	base := len(vm.stack) - int(argc)
+	result, err := vm.funcs.Call(int(fn), vm.stack[base:])
+	clear(vm.stack[base:])
+	vm.stack = vm.stack[:base]
+	if err != nil {
+		return &FuncError{Func: vm.funcs.Name(int(fn)), Line: int(line), Err: err}
+	}
+	vm.push(result)
+	return nil
 }
 
-func (vm *VM) handleFunctionCall(fnKeyIndex uint8) {
-	fnKey := vm.getConstantString(fnKeyIndex)
-	result := vm.callFunction(fnKey)
-	vm.buffer = append(vm.buffer, result...)
+func (vm *VM) loadVar(slot uint16) interface{} {
+	if int(slot) >= len(vm.vars) {
+		return nil
+	}
+	return vm.vars[slot]
 }
 
-func (vm *VM) resolveVar(path string) interface{} {
-	if path == "." {
-		if len(vm.loopStack) > 0 {
-			last := &vm.loopStack[len(vm.loopStack)-1]
-			slice := *(*[]interface{})(last.items)
-			return slice[last.index]
-		}
-		return vm.context
-	}
-	if path[0] == '.' {
-		key := path[1:]
-		if len(vm.loopStack) > 0 {
-			last := &vm.loopStack[len(vm.loopStack)-1]
-			slice := *(*[]interface{})(last.items)
-			item := slice[last.index]
-			if mapItem, ok := item.(map[string]interface{}); ok {
-				return mapItem[key]
-			}
+func (vm *VM) storeVar(slot uint16, value interface{}) {
+	if int(slot) >= len(vm.vars) {
+		if int(slot) < cap(vm.vars) {
+			vm.vars = vm.vars[:slot+1]
+		} else {
+			vars := make([]interface{}, slot+1, 2*int(slot)+2)
+			copy(vars, vm.vars)
+			vm.vars = vars
 		}
-		return vm.context[key]
 	}
-	return vm.context[path]
+	vm.vars[slot] = value
 }
 
-func (vm *VM) resolveAndWriteVar(path string) {
-	value := vm.resolveVar(path)
-	switch v := value.(type) {
-	case string:
-		vm.buffer = append(vm.buffer, v...)
-	case int:
-		vm.buffer = strconv.AppendInt(vm.buffer, int64(v), 10)
-	case float64:
-		vm.buffer = strconv.AppendFloat(vm.buffer, v, 'f', -1, 64)
-	default:
-		vm.buffer = append(vm.buffer, fmt.Sprintf("%v", v)...)
-	}
+// pushDot makes value the dot until the matching popDot. Range loops push
+// their current item and with blocks their value.
+func (vm *VM) pushDot(value interface{}) {
+	vm.dots = append(vm.dots, value)
 }
 
-func (vm *VM) callFunction(fnKey string) []byte {
+func (vm *VM) popDot() {
+	vm.dots[len(vm.dots)-1] = nil
+	vm.dots = vm.dots[:len(vm.dots)-1]
+}
 
-	switch fnKey {
-	case "upper":
-		arg1 := *(*interface{})(vm.registers[0])
-		return []byte(strings.ToUpper(arg1.(string)))
-	case "lower":
-		arg1 := *(*interface{})(vm.registers[0])
-		return []byte(strings.ToLower(arg1.(string)))
-	case "formatDate":
-		arg1 := *(*interface{})(vm.registers[0])
-		arg2 := *(*interface{})(vm.registers[1])
+// resolveVar resolves a path against the dot, which is the innermost loop item
+// or with value, or else the context. When the dot has no named members, such
+// as a string, a relative path falls back to the context. Under the
+// MissingKeyFail policy a path with a missing key is an error naming line,
+// unless it is optional.
+func (vm *VM) resolveVar(path bytecode.Path, line uint16, optional bool) (interface{}, error) {
+	value := vm.lookupVar(path)
+	if value == nil && !optional && vm.missingKey == MissingKeyFail && vm.isMissing(path) {
+		return nil, &MissingKeyError{Path: path.String(), Line: int(line)}
+	}
+	return value, nil
+}
 
-		date, err := time.Parse(time.RFC3339, arg1.(string))
-		if err != nil {
-			panic(err)
+func (vm *VM) lookupVar(path bytecode.Path) interface{} {
+	segments := path.Segments
+	if !path.Root && len(vm.dots) > 0 {
+		dot := vm.dots[len(vm.dots)-1]
+		if len(segments) == 0 {
+			return dot
+		}
+		if value, ok := access.Lookup(dot, segments[0]); ok {
+			return access.Path(value, segments[1:])
 		}
-		return []byte(date.Format(arg2.(string)))
-	default:
-		panic(fmt.Sprintf("unknown function: %s", fnKey))
 	}
+	return access.Path(vm.context, segments)
+}
+
+// resolveAndWriteVar writes the value of a path, or its placeholder under
+// the MissingKeyPlaceholder policy when a key along it is missing.
+func (vm *VM) resolveAndWriteVar(path bytecode.Path, line uint16) error {
+	value, err := vm.resolveVar(path, line, false)
+	if err != nil {
+		return err
+	}
+	if value == nil && vm.missingKey == MissingKeyPlaceholder && vm.isMissing(path) {
+		value = placeholder(path.String())
+	}
+	vm.writeValue(value)
+	return nil
+}
+
+// writeValue appends the text of a value to the output, formatted by
+// values.Append.
+func (vm *VM) writeValue(value interface{}) {
+	vm.buffer = values.Append(vm.buffer, value)
 }
@@ -125,12 +125,9 @@ func TestVMSafety(t *testing.T) {
 
 	context["items"] = "not a slice"
 	vm = NewVM(instructions, context, constants)
-	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("The code did not handle invalid loop variable properly")
-		}
-	}()
-	vm.Run()
+	if _, err := vm.Run(); err == nil || err.Error() != "cannot range over string" {
+		t.Errorf("Expected a range error for an invalid loop variable, got %v", err)
+	}
 
 	instructions = []bytecode.Instruction{
 		bytecode.PackInstruction(bytecode.OpResolvePrint, 0, 0, 0),
@@ -214,6 +211,37 @@ func TestVM_Run(t *testing.T) {
 			},
 			expected: "012",
 		},
+		{
+			name: "jump if false skips branch",
+			instructions: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpResolvePush, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpJumpIfFalse, 4, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrintConst, 1, 0, 0),
+				bytecode.PackInstruction(bytecode.OpJump, 5, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrintConst, 2, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+			context: map[string]interface{}{"items": []interface{}{}},
+			constants: []bytecode.Constant{
+				{Type: bytecode.ConstString, Value: ".items"},
+				{Type: bytecode.ConstString, Value: "some"},
+				{Type: bytecode.ConstString, Value: "none"},
+			},
+			expected: "none",
+		},
+		{
+			name: "print pushed constant",
+			instructions: []bytecode.Instruction{
+				bytecode.PackInstruction(bytecode.OpPushConst, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpPrint, 0, 0, 0),
+				bytecode.PackInstruction(bytecode.OpHalt, 0, 0, 0),
+			},
+			context: map[string]interface{}{},
+			constants: []bytecode.Constant{
+				{Type: bytecode.ConstBoolean, Value: true},
+			},
+			expected: "true",
+		},
 	}
 
 	for _, tt := range tests {
@@ -229,3 +257,185 @@ func TestVM_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestIsTruthy(t *testing.T) {
+	var nilMap map[string]interface{}
+	var nilPtr *int
+	n := 0
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected bool
+	}{
+		{"nil", nil, false},
+		{"true", true, true},
+		{"false", false, false},
+		{"empty string", "", false},
+		{"string", "a", true},
+		{"zero int", 0, false},
+		{"int", 3, true},
+		{"zero int64", int64(0), false},
+		{"negative int64", int64(-1), true},
+		{"zero float", 0.0, false},
+		{"float", 0.5, true},
+		{"zero uint8", uint8(0), false},
+		{"float32", float32(1.5), true},
+		{"empty slice", []interface{}{}, false},
+		{"slice", []string{"a"}, true},
+		{"empty typed slice", []int{}, false},
+		{"typed slice", []int{1}, true},
+		{"empty map", map[string]interface{}{}, false},
+		{"nil map", nilMap, false},
+		{"map", map[string]int{"a": 1}, true},
+		{"nil pointer", nilPtr, false},
+		{"pointer", &n, true},
+		{"struct", struct{}{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTruthy(tt.value); got != tt.expected {
+				t.Errorf("isTruthy(%#v) = %v, want %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValuesEqual(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     interface{}
+		expected bool
+		wantErr  bool
+	}{
+		{name: "int and int64", a: 3, b: int64(3), expected: true},
+		{name: "int and float", a: 3, b: 3.0, expected: true},
+		{name: "uint8 and int", a: uint8(7), b: 8, expected: false},
+		{name: "number and string", a: 1, b: "1", expected: false},
+		{name: "strings", a: "a", b: "a", expected: true},
+		{name: "bools", a: true, b: false, expected: false},
+		{name: "nil and nil", a: nil, b: nil, expected: true},
+		{name: "nil and typed nil", a: nil, b: (*int)(nil), expected: true},
+		{name: "nil and string", a: nil, b: "", expected: false},
+		{name: "slices", a: []int{1}, b: []int{1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := valuesEqual(tt.a, tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("valuesEqual() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("valuesEqual(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     interface{}
+		expected int
+		wantErr  bool
+	}{
+		{name: "ints", a: 1, b: 2, expected: -1},
+		{name: "int and float", a: 2, b: 1.5, expected: 1},
+		{name: "int32 and int64", a: int32(5), b: int64(5), expected: 0},
+		{name: "strings", a: "b", b: "a", expected: 1},
+		{name: "string and int", a: "1", b: 1, wantErr: true},
+		{name: "nil", a: nil, b: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compareValues(tt.a, tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compareValues() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("compareValues(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		name     string
+		op       byte
+		a, b     interface{}
+		expected interface{}
+		wantErr  bool
+	}{
+		{name: "int + int stays int", op: '+', a: 1, b: 2, expected: 3},
+		{name: "int + int64 promotes to int64", op: '+', a: 1, b: int64(2), expected: int64(3)},
+		{name: "int32 * int promotes to int64", op: '*', a: int32(4), b: 2, expected: int64(8)},
+		{name: "int64 - float64 promotes to float64", op: '-', a: int64(5), b: 0.5, expected: 4.5},
+		{name: "float32 + int", op: '+', a: float32(0.5), b: 1, expected: 1.5},
+		{name: "integer division truncates", op: '/', a: 7, b: 2, expected: 3},
+		{name: "float division", op: '/', a: 7.0, b: 2, expected: 3.5},
+		{name: "integer modulo", op: '%', a: -7, b: 3, expected: -1},
+		{name: "float modulo", op: '%', a: 7.5, b: 2, expected: 1.5},
+		{name: "uint plus int", op: '+', a: uint(3), b: 4, expected: int64(7)},
+		{name: "string concatenation", op: '+', a: "a", b: "b", expected: "ab"},
+		{name: "integer division by zero", op: '/', a: 1, b: 0, wantErr: true},
+		{name: "float modulo by zero", op: '%', a: 1.5, b: 0.0, wantErr: true},
+		{name: "string times int", op: '*', a: "a", b: 2, wantErr: true},
+		{name: "nil plus int", op: '+', a: nil, b: 2, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := arithmetic(tt.op, tt.a, tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("arithmetic() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("arithmetic(%v %c %v) = %#v, want %#v", tt.a, tt.op, tt.b, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	type row struct{ Name string }
+
+	tests := []struct {
+		name      string
+		container interface{}
+		key       interface{}
+		expected  interface{}
+		wantErr   bool
+	}{
+		{name: "slice", container: []interface{}{"a", "b"}, key: 1, expected: "b"},
+		{name: "typed slice", container: []int{1, 2}, key: int64(0), expected: 1},
+		{name: "array pointer", container: &[2]string{"a", "b"}, key: 1, expected: "b"},
+		{name: "negative index", container: []string{"a", "b"}, key: -2, expected: "a"},
+		{name: "out of range", container: []string{"a"}, key: 1, wantErr: true},
+		{name: "negative out of range", container: []string{"a"}, key: -2, wantErr: true},
+		{name: "float index", container: []string{"a"}, key: 0.5, wantErr: true},
+		{name: "string map", container: map[string]interface{}{"a-b": 1}, key: "a-b", expected: 1},
+		{name: "missing key", container: map[string]int{}, key: "a", expected: nil},
+		{name: "int map with int64", container: map[int]string{1: "x"}, key: int64(1), expected: "x"},
+		{name: "int map with string", container: map[int]string{1: "x"}, key: "1", wantErr: true},
+		{name: "string map with int", container: map[string]string{"1": "x"}, key: 1, wantErr: true},
+		{name: "struct", container: row{Name: "a"}, key: "Name", expected: "a"},
+		{name: "nil", container: nil, key: 0, expected: nil},
+		{name: "string", container: "abc", key: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := index(tt.container, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("index() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("index() = %#v, want %#v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -52,7 +52,7 @@ func BenchmarkDeserializeBytecode(b *testing.B) {
 
 func BenchmarkSerializeInstruction(b *testing.B) {
 	instruction := PackInstruction(OpPrintConst, 1, 2, 3)
-	buf := make([]byte, 4)
+	buf := make([]byte, 8)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		instruction.Serialize(buf)
@@ -61,12 +61,12 @@ func BenchmarkSerializeInstruction(b *testing.B) {
 
 func BenchmarkDeserializeInstruction(b *testing.B) {
 	instruction := PackInstruction(OpPrintConst, 1, 2, 3)
-	buf := make([]byte, 4)
+	buf := make([]byte, 8)
 	instruction.Serialize(buf)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		unpacked := UnpackedInstruction{}
-		unpacked.Unpack(Instruction(binary.LittleEndian.Uint32(buf)))
+		unpacked.Unpack(Instruction(binary.LittleEndian.Uint64(buf)))
 		if unpacked.Op != OpPrintConst || unpacked.A != 1 || unpacked.B != 2 || unpacked.C != 3 {
 			b.Fatalf("Deserialization failed: unexpected result")
 		}
@@ -77,7 +77,7 @@ func generateLargeInstructionSet(size int) []Instruction {
 	instructions := make([]Instruction, size)
 	for i := 0; i < size; i++ {
 		opcode := OpCode(i % 8)
-		instructions[i] = PackInstruction(opcode, uint8(i), uint8(i+1), uint8(i+2))
+		instructions[i] = PackInstruction(opcode, uint16(i), uint16(i+1), uint16(i+2))
 	}
 	return instructions
 }
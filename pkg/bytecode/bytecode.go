@@ -10,7 +10,7 @@ import (
 
 const (
 	MagicNumber uint32 = 0x53574150
-	Version     uint32 = 1
+	Version     uint32 = 5
 )
 
 type Header struct {
@@ -21,12 +21,12 @@ type Header struct {
 }
 
 func (i Instruction) Serialize(buf []byte) {
-	binary.LittleEndian.PutUint32(buf, uint32(i))
+	binary.LittleEndian.PutUint64(buf, uint64(i))
 }
 
-func SerializeInstruction(instruction Instruction) [4]byte {
-	var buf [4]byte
-	binary.LittleEndian.PutUint32(buf[:], uint32(instruction))
+func SerializeInstruction(instruction Instruction) [8]byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(instruction))
 	return buf
 }
 
@@ -62,15 +62,15 @@ func SerializeBytecode(w io.Writer, instructions []Instruction, constants []Cons
 		}
 	}
 
-	for i := 0; i < len(instructions); i += 1024 {
-		end := i + 1024
+	for i := 0; i < len(instructions); i += 512 {
+		end := i + 512
 		if end > len(instructions) {
 			end = len(instructions)
 		}
 		for j, instr := range instructions[i:end] {
-			binary.LittleEndian.PutUint32(buf[j*4:], uint32(instr))
+			binary.LittleEndian.PutUint64(buf[j*8:], uint64(instr))
 		}
-		if _, err := w.Write(buf[:(end-i)*4]); err != nil {
+		if _, err := w.Write(buf[:(end-i)*8]); err != nil {
 			return err
 		}
 	}
@@ -106,6 +106,29 @@ func writeConstant(w io.Writer, constant Constant, buf []byte) error {
 		}
 		_, err := w.Write(buf[:2])
 		return err
+	case ConstPath:
+		path := constant.Value.(Path)
+		buf[1] = 0
+		if path.Root {
+			buf[1] = 1
+		}
+		binary.LittleEndian.PutUint32(buf[2:], uint32(len(path.Segments)))
+		if _, err := w.Write(buf[:6]); err != nil {
+			return err
+		}
+		for _, segment := range path.Segments {
+			binary.LittleEndian.PutUint32(buf, uint32(len(segment)))
+			if _, err := w.Write(buf[:4]); err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte(segment)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ConstNil:
+		_, err := w.Write(buf[:1])
+		return err
 	default:
 		return fmt.Errorf("unknown constant type: %v", constant.Type)
 	}
@@ -139,12 +162,12 @@ func DeserializeBytecode(r io.Reader) ([]Instruction, []Constant, error) {
 	}
 
 	instructions := make([]Instruction, header.InstructionCount)
-	instructionBuf := buf[:4]
+	instructionBuf := buf[:8]
 	for i := uint32(0); i < header.InstructionCount; i++ {
 		if _, err := io.ReadFull(r, instructionBuf); err != nil {
 			return nil, nil, fmt.Errorf("failed to read instruction: %w", err)
 		}
-		instructions[i] = Instruction(binary.LittleEndian.Uint32(instructionBuf))
+		instructions[i] = Instruction(binary.LittleEndian.Uint64(instructionBuf))
 	}
 
 	return instructions, constants, nil
@@ -163,15 +186,16 @@ func readConstants(r io.Reader, constants []Constant, buf []byte) error {
 				return fmt.Errorf("failed to read string length: %w", err)
 			}
 			strLen := binary.LittleEndian.Uint32(buf[1:5])
-			if uint32(cap(buf)) < strLen {
-				buf = make([]byte, strLen)
+			strBuf := buf[:0]
+			if uint32(cap(strBuf)) < strLen {
+				strBuf = make([]byte, strLen)
 			} else {
-				buf = buf[:strLen]
+				strBuf = strBuf[:strLen]
 			}
-			if _, err := io.ReadFull(r, buf); err != nil {
+			if _, err := io.ReadFull(r, strBuf); err != nil {
 				return fmt.Errorf("failed to read string: %w", err)
 			}
-			constants[i] = Constant{Type: constType, Value: string(buf)}
+			constants[i] = Constant{Type: constType, Value: string(strBuf)}
 		case ConstInteger:
 			if _, err := io.ReadFull(r, buf[1:9]); err != nil {
 				return fmt.Errorf("failed to read integer: %w", err)
@@ -187,6 +211,27 @@ func readConstants(r io.Reader, constants []Constant, buf []byte) error {
 				return fmt.Errorf("failed to read boolean: %w", err)
 			}
 			constants[i] = Constant{Type: constType, Value: buf[1] != 0}
+		case ConstPath:
+			if _, err := io.ReadFull(r, buf[1:6]); err != nil {
+				return fmt.Errorf("failed to read path: %w", err)
+			}
+			path := Path{Root: buf[1] != 0}
+			if count := binary.LittleEndian.Uint32(buf[2:6]); count > 0 {
+				path.Segments = make([]string, count)
+			}
+			for j := range path.Segments {
+				if _, err := io.ReadFull(r, buf[:4]); err != nil {
+					return fmt.Errorf("failed to read path segment length: %w", err)
+				}
+				segment := make([]byte, binary.LittleEndian.Uint32(buf[:4]))
+				if _, err := io.ReadFull(r, segment); err != nil {
+					return fmt.Errorf("failed to read path segment: %w", err)
+				}
+				path.Segments[j] = string(segment)
+			}
+			constants[i] = Constant{Type: constType, Value: path}
+		case ConstNil:
+			constants[i] = Constant{Type: constType}
 		default:
 			return fmt.Errorf("unknown constant type: %v", constType)
 		}
@@ -3,6 +3,7 @@ package bytecode
 import (
 	"bytes"
 	"encoding/binary"
+	"reflect"
 	"testing"
 )
 
@@ -24,6 +25,32 @@ func TestSerializeDeserializeBytecode(t *testing.T) {
 				{Type: ConstInteger, Value: int64(42)},
 			},
 		},
+		{
+			name: "Path constants",
+			instructions: []Instruction{
+				PackInstruction(OpResolvePrint, 0, 0, 0),
+				PackInstruction(OpResolvePrint, 1, 0, 0),
+				PackInstruction(OpResolvePrint, 2, 0, 0),
+				PackInstruction(OpHalt, 0, 0, 0),
+			},
+			constants: []Constant{
+				{Type: ConstPath, Value: ParsePath(".user.name.first")},
+				{Type: ConstPath, Value: ParsePath(".")},
+				{Type: ConstPath, Value: ParsePath("title")},
+			},
+		},
+		{
+			name: "Nil constant",
+			instructions: []Instruction{
+				PackInstruction(OpPushConst, 0, 0, 0),
+				PackInstruction(OpPrint, 0, 0, 0),
+				PackInstruction(OpHalt, 0, 0, 0),
+			},
+			constants: []Constant{
+				{Type: ConstNil},
+				{Type: ConstBoolean, Value: true},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -85,8 +112,38 @@ func TestSerializeDeserializeBytecode(t *testing.T) {
 					if original.Value != deserializedConstants[i].Value {
 						t.Errorf("Constant %d mismatch. Expected: %v, Got: %v", i, original, deserializedConstants[i])
 					}
+				case ConstPath:
+					if !reflect.DeepEqual(original.Value, deserializedConstants[i].Value) {
+						t.Errorf("Constant %d mismatch. Expected: %#v, Got: %#v", i, original.Value, deserializedConstants[i].Value)
+					}
 				}
 			}
 		})
 	}
 }
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Path
+		text     string
+	}{
+		{input: ".", expected: Path{}, text: "."},
+		{input: ".name", expected: Path{Segments: []string{"name"}}, text: ".name"},
+		{input: ".user.name.first", expected: Path{Segments: []string{"user", "name", "first"}}, text: ".user.name.first"},
+		{input: "name", expected: Path{Root: true, Segments: []string{"name"}}, text: "$.name"},
+		{input: "user.name", expected: Path{Root: true, Segments: []string{"user", "name"}}, text: "$.user.name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			path := ParsePath(tt.input)
+			if !reflect.DeepEqual(path, tt.expected) {
+				t.Errorf("ParsePath(%q) = %#v, want %#v", tt.input, path, tt.expected)
+			}
+			if path.String() != tt.text {
+				t.Errorf("String() = %q, want %q", path.String(), tt.text)
+			}
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package bytecode
 
+import "strings"
+
 type ConstantType int
 
 const (
@@ -7,9 +9,47 @@ const (
 	ConstInteger
 	ConstFloat
 	ConstBoolean
+	ConstPath
+	ConstNil
 )
 
 type Constant struct {
 	Type  ConstantType
 	Value interface{}
 }
+
+// Path is an accessor such as .user.name, split into its segments at compile
+// time. A relative path with no segments is the dot itself. Root paths are
+// looked up on the template context rather than on the dot.
+type Path struct {
+	Root     bool
+	Segments []string
+}
+
+// ParsePath splits an accessor. ".user.name" is relative to the dot, while a
+// bare "user.name" is looked up on the root context.
+func ParsePath(s string) Path {
+	var path Path
+	if !strings.HasPrefix(s, ".") {
+		path.Root = true
+	} else {
+		s = s[1:]
+	}
+	if s != "" {
+		path.Segments = strings.Split(s, ".")
+	}
+	return path
+}
+
+// String returns the path as written against the dot, such as .user.name,
+// or against the root context, such as $.user.name.
+func (p Path) String() string {
+	s := "." + strings.Join(p.Segments, ".")
+	if p.Root {
+		if len(p.Segments) == 0 {
+			return "$"
+		}
+		return "$" + s
+	}
+	return s
+}
@@ -15,13 +15,40 @@ type OpCode byte
 const (
 	OpPrintConst OpCode = iota
 	OpResolvePrint
-	OpMove
 	OpCall
-	OpLoadConst
-	OpResolveLoad
 	OpLoopStart
 	OpLoopEnd
 	OpHalt
+	OpPushConst
+	OpResolvePush
+	OpPrint
+	OpJump
+	OpJumpIfFalse
+	OpJumpIfFalseOrPop
+	OpJumpIfTrueOrPop
+	OpNot
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpLessEqual
+	OpGreater
+	OpGreaterEqual
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpModulo
+	OpNegate
+	OpLoadVar
+	OpStoreVar
+	OpField
+	OpLoopMeta
+	OpLoopStartValue
+	OpLoopBreak
+	OpIndex
+	OpWithStart
+	OpWithEnd
+	OpJumpIfNotNilOrPop
 )
 
 func (op OpCode) String() string {
@@ -30,8 +57,6 @@ func (op OpCode) String() string {
 		return "OpPrintConst"
 	case OpResolvePrint:
 		return "OpResolvePrint"
-	case OpMove:
-		return "OpMove"
 	case OpCall:
 		return "OpCall"
 	case OpLoopStart:
@@ -40,20 +65,98 @@ func (op OpCode) String() string {
 		return "OpLoopEnd"
 	case OpHalt:
 		return "OpHalt"
-	case OpResolveLoad:
-		return "OpResolveLoad"
-	case OpLoadConst:
-		return "OpLoadConst"
+	case OpPushConst:
+		return "OpPushConst"
+	case OpResolvePush:
+		return "OpResolvePush"
+	case OpPrint:
+		return "OpPrint"
+	case OpJump:
+		return "OpJump"
+	case OpJumpIfFalse:
+		return "OpJumpIfFalse"
+	case OpJumpIfFalseOrPop:
+		return "OpJumpIfFalseOrPop"
+	case OpJumpIfTrueOrPop:
+		return "OpJumpIfTrueOrPop"
+	case OpNot:
+		return "OpNot"
+	case OpEqual:
+		return "OpEqual"
+	case OpNotEqual:
+		return "OpNotEqual"
+	case OpLess:
+		return "OpLess"
+	case OpLessEqual:
+		return "OpLessEqual"
+	case OpGreater:
+		return "OpGreater"
+	case OpGreaterEqual:
+		return "OpGreaterEqual"
+	case OpAdd:
+		return "OpAdd"
+	case OpSubtract:
+		return "OpSubtract"
+	case OpMultiply:
+		return "OpMultiply"
+	case OpDivide:
+		return "OpDivide"
+	case OpModulo:
+		return "OpModulo"
+	case OpNegate:
+		return "OpNegate"
+	case OpLoadVar:
+		return "OpLoadVar"
+	case OpStoreVar:
+		return "OpStoreVar"
+	case OpField:
+		return "OpField"
+	case OpLoopMeta:
+		return "OpLoopMeta"
+	case OpLoopStartValue:
+		return "OpLoopStartValue"
+	case OpLoopBreak:
+		return "OpLoopBreak"
+	case OpIndex:
+		return "OpIndex"
+	case OpWithStart:
+		return "OpWithStart"
+	case OpWithEnd:
+		return "OpWithEnd"
+	case OpJumpIfNotNilOrPop:
+		return "OpJumpIfNotNilOrPop"
 	default:
 		return "Unknown"
 	}
 }
 
+// LoopField selects what OpLoopMeta pushes for the innermost loop.
+type LoopField uint16
+
+const (
+	LoopKey LoopField = iota
+	LoopValue
+	LoopIndex
+	LoopIndex0
+	LoopFirst
+	LoopLast
+	LoopLength
+)
+
+// LoopFields maps the fields of $loop to the value OpLoopMeta pushes.
+var LoopFields = map[string]LoopField{
+	"index":  LoopIndex,
+	"index0": LoopIndex0,
+	"first":  LoopFirst,
+	"last":   LoopLast,
+	"length": LoopLength,
+}
+
 type UnpackedInstruction struct {
 	Op OpCode
-	A  uint8
-	B  uint8
-	C  uint8
+	A  uint16
+	B  uint16
+	C  uint16
 }
 
 func (u *UnpackedInstruction) Reset() {
@@ -65,11 +168,11 @@ func (u *UnpackedInstruction) Reset() {
 
 func (u *UnpackedInstruction) Unpack(i Instruction) {
 	u.Op = OpCode(i & 0xFF)
-	u.A = uint8((i >> 8) & 0xFF)
-	u.B = uint8((i >> 24) & 0xFF)
-	u.C = uint8((i >> 40) & 0xFF)
+	u.A = uint16((i >> 8) & 0xFFFF)
+	u.B = uint16((i >> 24) & 0xFFFF)
+	u.C = uint16((i >> 40) & 0xFFFF)
 }
 
-func PackInstruction(op OpCode, a, b, c uint8) Instruction {
+func PackInstruction(op OpCode, a, b, c uint16) Instruction {
 	return Instruction(uint64(op) | uint64(a)<<8 | uint64(b)<<24 | uint64(c)<<40)
 }
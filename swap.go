@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/flothq/swap/internal/compiler"
+	"github.com/flothq/swap/internal/funcs"
 	"github.com/flothq/swap/internal/lexer"
 	"github.com/flothq/swap/internal/lru"
 	"github.com/flothq/swap/internal/vm"
@@ -16,16 +18,63 @@ import (
 
 type Engine struct {
 	cache      *lru.Cache[string, *vm.Program]
+	funcs      *funcs.Table
 	engineOpts EngineOpts
 }
 
 type EngineOpts struct {
 	CacheSize    int
 	CacheEnabled bool
+	// Funcs are custom functions templates can call in addition to the
+	// built-in ones, which they replace when the names are the same.
+	Funcs map[string]any
+	// DisableStringFuncs leaves out the built-in string functions (trim,
+	// replace, split, truncate, wrap, ...), so their names are free and
+	// templates cannot call them.
+	DisableStringFuncs bool
+	// Now is the clock the now and relative functions read the current
+	// time from. It defaults to time.Now.
+	Now func() time.Time
+	// MissingKey selects what templates do with keys the context does not
+	// have: render them as empty, which is the default, print a placeholder
+	// or fail. A program's own MissingKey takes precedence.
+	MissingKey MissingKeyPolicy
 }
 
 type EngineOption func(*EngineOpts)
 
+// FuncError is the error Execute and Run return, wrapped, when a template
+// function returns an error or panics. It carries the function name and the
+// template line of the call; use errors.As to retrieve it.
+type FuncError = vm.FuncError
+
+// MissingKeyPolicy selects what happens when a template refers to a key the
+// context does not have. A missing key is one absent from a map or struct,
+// or below a nil value; a key that is present with a nil value is not
+// missing. The left side of ?? and the first argument of default are never
+// subject to the policy.
+type MissingKeyPolicy = vm.MissingKeyPolicy
+
+const (
+	// MissingKeyDefault uses the engine's policy in a program, and renders
+	// missing keys as empty in EngineOpts.
+	MissingKeyDefault = vm.MissingKeyDefault
+	// MissingKeyEmpty renders missing keys as empty.
+	MissingKeyEmpty = vm.MissingKeyEmpty
+	// MissingKeyPlaceholder prints a missing key as [missing .path] when an
+	// action prints a path, a variable field or an index directly. Missing
+	// keys used in expressions, such as conditions and function arguments,
+	// are nil as usual.
+	MissingKeyPlaceholder = vm.MissingKeyPlaceholder
+	// MissingKeyFail stops rendering with a MissingKeyError.
+	MissingKeyFail = vm.MissingKeyFail
+)
+
+// MissingKeyError is the error Execute and Run return, wrapped, for a missing
+// key under MissingKeyFail. It carries the full path of the key and its
+// template line; use errors.As to retrieve it.
+type MissingKeyError = vm.MissingKeyError
+
 func WithCacheEnabled(enabled bool) EngineOption {
 	return func(opts *EngineOpts) {
 		opts.CacheEnabled = enabled
@@ -38,6 +87,46 @@ func WithCacheSize(size int) EngineOption {
 	}
 }
 
+// WithFuncs registers custom functions. Each value must be a Go function
+// returning one value, or a value and an error that aborts rendering when it
+// is not nil. Arguments are converted to its parameter types when they are
+// called.
+func WithFuncs(fns map[string]any) EngineOption {
+	return func(opts *EngineOpts) {
+		if opts.Funcs == nil {
+			opts.Funcs = make(map[string]any, len(fns))
+		}
+		for name, fn := range fns {
+			opts.Funcs[name] = fn
+		}
+	}
+}
+
+// WithStringFuncs enables or disables the built-in string functions, which
+// are enabled by default.
+func WithStringFuncs(enabled bool) EngineOption {
+	return func(opts *EngineOpts) {
+		opts.DisableStringFuncs = !enabled
+	}
+}
+
+// WithClock sets the clock the now and relative functions read the current
+// time from, so output that depends on it can be tested.
+func WithClock(now func() time.Time) EngineOption {
+	return func(opts *EngineOpts) {
+		opts.Now = now
+	}
+}
+
+// WithMissingKey sets the policy for keys the context does not have.
+func WithMissingKey(policy MissingKeyPolicy) EngineOption {
+	return func(opts *EngineOpts) {
+		opts.MissingKey = policy
+	}
+}
+
+// NewEngine returns an engine configured by opts. It panics if a function
+// registered with WithFuncs is not a valid template function.
 func NewEngine(opts ...EngineOption) *Engine {
 	e := &Engine{
 		engineOpts: EngineOpts{},
@@ -45,6 +134,11 @@ func NewEngine(opts ...EngineOption) *Engine {
 	for _, opt := range opts {
 		opt(&e.engineOpts)
 	}
+	table, err := funcs.New(e.engineOpts.Funcs, e.engineOpts.funcOptions()...)
+	if err != nil {
+		panic(fmt.Sprintf("swap: %v", err))
+	}
+	e.funcs = table
 	if e.engineOpts.CacheEnabled {
 		if e.engineOpts.CacheSize <= 0 {
 			e.engineOpts.CacheSize = 1024 * 1024
@@ -56,13 +150,27 @@ func NewEngine(opts ...EngineOption) *Engine {
 	return e
 }
 
+// funcOptions configures the built-in functions as the options ask.
+func (o *EngineOpts) funcOptions() []funcs.Option {
+	opts := []funcs.Option{funcs.Clock(o.Now)}
+	if o.DisableStringFuncs {
+		opts = append(opts, funcs.Omit(funcs.Strings))
+	}
+	return opts
+}
+
 var programPool = sync.Pool{
 	New: func() interface{} {
 		return &vm.Program{}
 	},
 }
 
-func (e *Engine) Execute(template string, context map[string]interface{}) ([]byte, error) {
+// Execute renders template against context, which may be a map, a struct or a
+// pointer to either. Struct fields are looked up by name or by their
+// `swap:"name"` tag. It never panics: a panic while compiling or rendering is
+// returned as an error.
+func (e *Engine) Execute(template string, context any) (result []byte, err error) {
+	defer recoverError(&err)
 
 	var program *vm.Program
 	if e.cache != nil {
@@ -88,7 +196,7 @@ func (e *Engine) Execute(template string, context map[string]interface{}) ([]byt
 		}
 	}
 
-	result, err := e.Run(program, context)
+	result, err = e.Run(program, context)
 	if err != nil {
 		return nil, fmt.Errorf("execution error: %w", err)
 	}
@@ -96,6 +204,16 @@ func (e *Engine) Execute(template string, context map[string]interface{}) ([]byt
 	return result, nil
 }
 
+// recoverError turns a panic into an error stored in *err. It must be
+// deferred directly.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("swap: panic: %v", r)
+	}
+}
+
+// Compile compiles template into a program for Run. Setting the program's
+// MissingKey overrides the engine's missing-key policy for that template.
 func (e *Engine) Compile(template string) (*vm.Program, error) {
 	buf, err := e.compile(template)
 	if err != nil {
@@ -107,7 +225,7 @@ func (e *Engine) Compile(template string) (*vm.Program, error) {
 		return nil, fmt.Errorf("failed to deserialize bytecode: %w", err)
 	}
 
-	program := vm.NewProgram(instructions, constants)
+	program := vm.NewProgram(instructions, constants, e.funcs)
 
 	if e.cache != nil {
 		e.cache.Set(template, program)
@@ -120,10 +238,14 @@ func (e *Engine) compile(template string) (*bytes.Buffer, error) {
 
 	lex := lexer.NewLexer(template)
 	defer lex.Release()
-	tokens := lex.Lex()
+	tokens, err := lex.Lex()
+	if err != nil {
+		return nil, fmt.Errorf("syntax error: %w", err)
+	}
 
 	comp := compiler.NewCompiler(tokens)
 	defer comp.Release()
+	comp.SetFuncs(e.funcs)
 	instructions, constants, err := comp.Compile(tokens)
 	if err != nil {
 		return nil, fmt.Errorf("compilation error: %w", err)
@@ -146,15 +268,32 @@ func (e *Engine) deserializeBytecode(r io.Reader) (*vm.Program, error) {
 	program := programPool.Get().(*vm.Program)
 	program.Instructions = instructions
 	program.Constants = constants
+	program.Funcs = e.funcs
+	program.MissingKey = MissingKeyDefault
 
 	return program, nil
 }
 
-func (e *Engine) Run(program *vm.Program, context map[string]interface{}) ([]byte, error) {
+// Run renders a compiled program against context. Like Execute, it returns a
+// panic while rendering as an error. The program's MissingKey, when set,
+// overrides the engine's missing-key policy. A program compiled by an engine
+// with other functions is refused, since its calls would reach the wrong
+// ones.
+func (e *Engine) Run(program *vm.Program, context any) (result []byte, err error) {
+	defer recoverError(&err)
+	if program.Funcs != e.funcs {
+		return nil, fmt.Errorf("program was compiled against the functions of another engine")
+	}
 	vm := vm.NewVM(program.Instructions, context, program.Constants)
 	defer vm.Release()
+	vm.SetFuncs(e.funcs)
+	if program.MissingKey != MissingKeyDefault {
+		vm.SetMissingKey(program.MissingKey)
+	} else {
+		vm.SetMissingKey(e.engineOpts.MissingKey)
+	}
 
-	result, err := vm.Run()
+	result, err = vm.Run()
 	if err != nil {
 		return nil, fmt.Errorf("VM execution failed: %w", err)
 	}
@@ -42,3 +42,19 @@ func BenchmarkRun(b *testing.B) {
 		_, _ = engine.Run(program, context)
 	}
 }
+
+func BenchmarkRunStruct(b *testing.B) {
+	engine := NewEngine()
+	template := generateTemplate()
+	program, err := engine.Compile(template)
+	if err != nil {
+		b.Fatal(err)
+	}
+	context := struct {
+		Name string `swap:"name"`
+	}{Name: "World"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = engine.Run(program, &context)
+	}
+}
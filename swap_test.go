@@ -1,7 +1,13 @@
 package swap
 
 import (
+	"errors"
+	"iter"
+	"maps"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestExecute(t *testing.T) {
@@ -34,6 +40,762 @@ func TestExecute(t *testing.T) {
 			expected: "Users: AliceBobCharlieDavidEve",
 			wantErr:  false,
 		},
+		{
+			name:     "If true branch",
+			template: "{{ if .admin }}Admin{{ else }}User{{ end }}",
+			context:  map[string]interface{}{"admin": true},
+			expected: "Admin",
+		},
+		{
+			name:     "If else branch",
+			template: "{{ if .admin }}Admin{{ else }}User{{ end }}",
+			context:  map[string]interface{}{"admin": false},
+			expected: "User",
+		},
+		{
+			name:     "Else if chain",
+			template: "{{if .a}}A{{else if .b}}B{{else if .c}}C{{else}}D{{end}}",
+			context:  map[string]interface{}{"a": "", "b": 0, "c": []string{"x"}},
+			expected: "C",
+		},
+		{
+			name:     "If without else on missing key",
+			template: "[{{if .missing}}shown{{end}}]",
+			context:  map[string]interface{}{},
+			expected: "[]",
+		},
+		{
+			name:     "If inside range",
+			template: "{{range .users}}{{if .active}}{{.name}} {{end}}{{end}}",
+			context: map[string]interface{}{
+				"users": []interface{}{
+					map[string]interface{}{"name": "Alice", "active": true},
+					map[string]interface{}{"name": "Bob", "active": false},
+					map[string]interface{}{"name": "Eve", "active": true},
+				},
+			},
+			expected: "Alice Eve ",
+		},
+		{
+			name:     "Unclosed if",
+			template: "{{if .a}}A",
+			context:  map[string]interface{}{},
+			wantErr:  true,
+		},
+		{
+			name:     "Comparison with boolean operators",
+			template: "{{if .total > 100 && !.vip}}fee{{else}}free{{end}}",
+			context:  map[string]interface{}{"total": 150.5, "vip": false},
+			expected: "fee",
+		},
+		{
+			name:     "Or binds looser than and",
+			template: "{{if .a && .b || .c}}yes{{else}}no{{end}}",
+			context:  map[string]interface{}{"a": true, "b": false, "c": true},
+			expected: "yes",
+		},
+		{
+			name:     "Parenthesised condition",
+			template: "{{if .a && (.b || .c)}}yes{{else}}no{{end}}",
+			context:  map[string]interface{}{"a": false, "b": true, "c": true},
+			expected: "no",
+		},
+		{
+			name:     "String equality",
+			template: "{{if .status == \"paid\"}}Paid{{end}}{{if .status != 'open'}}!{{end}}",
+			context:  map[string]interface{}{"status": "paid"},
+			expected: "Paid!",
+		},
+		{
+			name:     "Nil literal",
+			template: "{{if .a == nil}}a{{end}}{{if .b != nil}}b{{end}}{{if nil}}x{{end}}[{{ nil }}]",
+			context:  map[string]interface{}{"a": nil, "b": 0, "nil": "key"},
+			expected: "ab[]",
+		},
+		{
+			name:     "Print comparison result",
+			template: "{{ .count >= 3 }} {{ !.count }}",
+			context:  map[string]interface{}{"count": int64(3)},
+			expected: "true false",
+		},
+		{
+			name:     "Or evaluates to deciding operand",
+			template: "{{ .nickname || .name }}",
+			context:  map[string]interface{}{"nickname": "", "name": "Alice"},
+			expected: "Alice",
+		},
+		{
+			name:     "Ordering mismatched types",
+			template: "{{if .name < 3}}x{{end}}",
+			context:  map[string]interface{}{"name": "Alice"},
+			wantErr:  true,
+		},
+		{
+			name:     "Arithmetic on ints",
+			template: "{{ .qty * .price }} {{ .index + 1 }} {{ 7 / 2 }} {{ 7 % 3 }} {{ -.index }}",
+			context:  map[string]interface{}{"qty": 3, "price": 4, "index": 0},
+			expected: "12 1 3 1 0",
+		},
+		{
+			name:     "Arithmetic promotes to float",
+			template: "{{ .qty * .price }} {{ 7 / 2.0 }} {{ (1 + 2) * 1.5 }}",
+			context:  map[string]interface{}{"qty": int64(3), "price": 2.5},
+			expected: "7.5 3.5 4.5",
+		},
+		{
+			name:     "Arithmetic in condition",
+			template: "{{if .qty * .price > 100}}bulk{{else}}retail{{end}}",
+			context:  map[string]interface{}{"qty": 30, "price": 4.0},
+			expected: "bulk",
+		},
+		{
+			name:     "String concatenation",
+			template: "{{ .first + \" \" + .last }}",
+			context:  map[string]interface{}{"first": "Ada", "last": "Lovelace"},
+			expected: "Ada Lovelace",
+		},
+		{
+			name:     "Division by zero",
+			template: "{{ .a / .b }}",
+			context:  map[string]interface{}{"a": 1, "b": 0},
+			wantErr:  true,
+		},
+		{
+			name:     "Arithmetic on string",
+			template: "{{ .a * 2 }}",
+			context:  map[string]interface{}{"a": "x"},
+			wantErr:  true,
+		},
+		{
+			name:     "Pipeline",
+			template: "{{ .name | lower | upper }}",
+			context:  map[string]interface{}{"name": "Ada"},
+			expected: "ADA",
+		},
+		{
+			name:     "Pipeline passes value as first argument",
+			template: "{{ .created | formatDate(\"2006-01-02\") | lower }}",
+			context:  map[string]interface{}{"created": "2024-03-05T10:00:00Z"},
+			expected: "2024-03-05",
+		},
+		{
+			name:     "Pipeline from function call",
+			template: "{{ lower(.name) | upper }}",
+			context:  map[string]interface{}{"name": "Ada"},
+			expected: "ADA",
+		},
+		{
+			name:     "Pipeline from expression",
+			template: "{{ .first + .last | upper }}",
+			context:  map[string]interface{}{"first": "a", "last": "b"},
+			expected: "AB",
+		},
+		{
+			name:     "Function call inside loop resolves item",
+			template: "{{range .users}}{{upper(.name)}} {{end}}",
+			context: map[string]interface{}{
+				"users": []interface{}{
+					map[string]interface{}{"name": "Alice"},
+					map[string]interface{}{"name": "Bob"},
+				},
+			},
+			expected: "ALICE BOB ",
+		},
+		{
+			name:     "Variable stores function result",
+			template: "{{ $year := formatDate(.created, \"2006\") }}{{ $year }}/{{ $year }}",
+			context:  map[string]interface{}{"created": "2024-03-05T10:00:00Z"},
+			expected: "2024/2024",
+		},
+		{
+			name:     "Variable field access",
+			template: "{{ $u := .user }}{{ $u.name }} ({{ $u.address.city }})",
+			context: map[string]interface{}{
+				"user": map[string]interface{}{
+					"name":    "Ada",
+					"address": map[string]interface{}{"city": "London"},
+				},
+			},
+			expected: "Ada (London)",
+		},
+		{
+			name:     "Variable assigned from pipeline",
+			template: "{{ $n := .name | upper }}{{ if $n == \"ADA\" }}yes{{ end }}",
+			context:  map[string]interface{}{"name": "Ada"},
+			expected: "yes",
+		},
+		{
+			name:     "Assignment updates outer variable",
+			template: "{{ $total := 0 }}{{ range .items }}{{ $total = $total + . }}{{ end }}{{ $total }}",
+			context:  map[string]interface{}{"items": []interface{}{1, 2, 3}},
+			expected: "6",
+		},
+		{
+			name:     "Declaration in block shadows outer variable",
+			template: "{{ $x := 1 }}{{ if true }}{{ $x := 2 }}{{ $x }}{{ end }}{{ $x }}",
+			context:  map[string]interface{}{},
+			expected: "21",
+		},
+		{
+			name:     "Variable is scoped to its block",
+			template: "{{ range .items }}{{ $x := . }}{{ end }}{{ $x }}",
+			context:  map[string]interface{}{"items": []interface{}{1}},
+			wantErr:  true,
+		},
+		{
+			name:     "Variable is scoped to its branch",
+			template: "{{ if .a }}{{ $x := 1 }}{{ else }}{{ $x }}{{ end }}",
+			context:  map[string]interface{}{},
+			wantErr:  true,
+		},
+		{
+			name:     "Range with index and item",
+			template: "{{ range $i, $item := .items }}{{ $i }}={{ $item }} {{ end }}",
+			context:  map[string]interface{}{"items": []interface{}{"a", "b"}},
+			expected: "0=a 1=b ",
+		},
+		{
+			name:     "Range with item only",
+			template: "{{ range $user := .users }}{{ $user.name }};{{ end }}",
+			context: map[string]interface{}{
+				"users": []interface{}{
+					map[string]interface{}{"name": "Alice"},
+					map[string]interface{}{"name": "Bob"},
+				},
+			},
+			expected: "Alice;Bob;",
+		},
+		{
+			name:     "Loop metadata",
+			template: "{{ range .items }}{{ $loop.index }}/{{ $loop.length }} {{ . }}{{ if !$loop.last }}, {{ end }}{{ end }}",
+			context:  map[string]interface{}{"items": []interface{}{"a", "b", "c"}},
+			expected: "1/3 a, 2/3 b, 3/3 c",
+		},
+		{
+			name:     "Loop first and index0",
+			template: "{{ range .items }}{{ if $loop.first }}[{{ end }}{{ $loop.index0 }}{{ end }}]",
+			context:  map[string]interface{}{"items": []interface{}{"a", "b"}},
+			expected: "[01]",
+		},
+		{
+			name:     "Nested loops see their own bindings",
+			template: "{{ range $g := .groups }}{{ range $m := $g.members }}{{ $g.name }}:{{ $m }} {{ end }}{{ end }}",
+			context: map[string]interface{}{
+				"groups": []interface{}{
+					map[string]interface{}{"name": "x", "members": []interface{}{"1", "2"}},
+					map[string]interface{}{"name": "y", "members": []interface{}{"3"}},
+				},
+			},
+			expected: "x:1 x:2 y:3 ",
+		},
+		{
+			name:     "Unknown loop field",
+			template: "{{ range .items }}{{ $loop.foo }}{{ end }}",
+			context:  map[string]interface{}{"items": []interface{}{"a"}},
+			wantErr:  true,
+		},
+		{
+			name:     "Range over map in key order",
+			template: "{{ range $k, $v := .headers }}{{ $k }}: {{ $v }}\n{{ end }}",
+			context: map[string]interface{}{
+				"headers": map[string]interface{}{
+					"Content-Type":   "text/html",
+					"Accept":         "*/*",
+					"X-Request-Id":   42,
+					"Cache-Control":  "no-cache",
+					"Content-Length": 12,
+				},
+			},
+			expected: "Accept: */*\nCache-Control: no-cache\nContent-Length: 12\nContent-Type: text/html\nX-Request-Id: 42\n",
+		},
+		{
+			name:     "Range over map binds dot to value",
+			template: "{{ range .scores }}{{ . }},{{ end }}",
+			context:  map[string]interface{}{"scores": map[string]int{"b": 2, "a": 1, "c": 3}},
+			expected: "1,2,3,",
+		},
+		{
+			name:     "Range over map with integer keys",
+			template: "{{ range $k, $v := .byYear }}{{ $k }}={{ $v }} {{ end }}",
+			context:  map[string]interface{}{"byYear": map[int]string{2024: "c", 9: "a", 100: "b"}},
+			expected: "9=a 100=b 2024=c ",
+		},
+		{
+			name:     "Range and join agree on map key order",
+			template: "{{ range .m }}{{ . }}{{ end }}|{{ join(.m, \"\") }}|{{ first(.m) }}{{ last(.m) }}",
+			context:  map[string]interface{}{"m": map[interface{}]string{2.5: "b", 10: "c", uint8(1): "a", "x": "d", true: "e"}},
+			expected: "abced|abced|ad",
+		},
+		{
+			name:     "Range over map with loop metadata",
+			template: "{{ range $k, $v := .m }}{{ $k }}{{ if !$loop.last }}|{{ end }}{{ end }}",
+			context:  map[string]interface{}{"m": map[string]string{"z": "", "y": "", "x": ""}},
+			expected: "x|y|z",
+		},
+		{
+			name:     "Range else on empty slice",
+			template: "{{ range .items }}{{ . }}{{ else }}No items{{ end }}",
+			context:  map[string]interface{}{"items": []interface{}{}},
+			expected: "No items",
+		},
+		{
+			name:     "Range else on missing key",
+			template: "{{ range .items }}{{ . }}{{ else }}No items{{ end }}",
+			context:  map[string]interface{}{},
+			expected: "No items",
+		},
+		{
+			name:     "Range else on nil typed slice",
+			template: "{{ range $i, $s := .items }}{{ $s }}{{ else }}No items{{ end }}",
+			context:  map[string]interface{}{"items": []string(nil)},
+			expected: "No items",
+		},
+		{
+			name:     "Range else skipped when not empty",
+			template: "{{ range .items }}{{ . }}{{ else }}No items{{ end }}!",
+			context:  map[string]interface{}{"items": []string{"a", "b"}},
+			expected: "ab!",
+		},
+		{
+			name:     "Range without else on empty map",
+			template: "[{{ range .m }}{{ . }}{{ end }}]",
+			context:  map[string]interface{}{"m": map[string]int{}},
+			expected: "[]",
+		},
+		{
+			name:     "Range else on empty channel",
+			template: "{{ range .ch }}{{ . }}{{ else }}closed{{ end }}",
+			context:  map[string]interface{}{"ch": closedChannel()},
+			expected: "closed",
+		},
+		{
+			name:     "Range else inside outer loop",
+			template: "{{ range .groups }}{{ range .members }}{{ . }}{{ else }}-{{ end }};{{ end }}",
+			context: map[string]interface{}{"groups": []interface{}{
+				map[string]interface{}{"members": []string{"a"}},
+				map[string]interface{}{"members": []string{}},
+			}},
+			expected: "a;-;",
+		},
+		{
+			name:     "Break after first matches",
+			template: "{{ $n := 0 }}{{ range .nums }}{{ if . % 2 == 0 }}{{ . }} {{ $n = $n + 1 }}{{ if $n == 2 }}{{ break }}{{ end }}{{ end }}{{ end }}done",
+			context:  map[string]interface{}{"nums": []int{1, 2, 3, 4, 5, 6, 8}},
+			expected: "2 4 done",
+		},
+		{
+			name:     "Continue skips rows",
+			template: "{{ range .nums }}{{ if . == 2 }}{{ continue }}{{ end }}{{ . }}{{ end }}",
+			context:  map[string]interface{}{"nums": []int{1, 2, 3}},
+			expected: "13",
+		},
+		{
+			name:     "Break in inner loop keeps outer loop running",
+			template: "{{ range $row := .rows }}{{ range $row }}{{ if . > 1 }}{{ break }}{{ end }}{{ . }}{{ end }};{{ end }}",
+			context:  map[string]interface{}{"rows": []interface{}{[]int{1, 2, 3}, []int{0, 5}}},
+			expected: "1;0;",
+		},
+		{
+			name:     "Continue after inner loop",
+			template: "{{ range .rows }}{{ range . }}{{ . }}{{ end }}{{ continue }}!{{ end }}",
+			context:  map[string]interface{}{"rows": []interface{}{[]int{1}, []int{2}}},
+			expected: "12",
+		},
+		{
+			name:     "Break skips range else",
+			template: "{{ range .items }}{{ break }}{{ else }}empty{{ end }}!",
+			context:  map[string]interface{}{"items": []string{"a"}},
+			expected: "!",
+		},
+		{
+			name:     "Break in range else",
+			template: "{{ range .items }}{{ else }}{{ break }}{{ end }}",
+			context:  map[string]interface{}{},
+			wantErr:  true,
+		},
+		{
+			name:     "Break in nested range else leaves outer range",
+			template: "{{ range .a }}{{ .n }}{{ range .b }}{{ . }}{{ else }}{{ break }}{{ end }};{{ end }}",
+			context: map[string]interface{}{"a": []interface{}{
+				map[string]interface{}{"n": 1, "b": []string{"x"}},
+				map[string]interface{}{"n": 2, "b": []string{}},
+				map[string]interface{}{"n": 3, "b": []string{"x"}},
+			}},
+			expected: "1x;2",
+		},
+		{
+			name:     "Continue in nested range else continues outer range",
+			template: "{{ range .a }}{{ .n }}{{ range .b }}{{ . }}{{ else }}{{ with .n }}{{ continue }}{{ end }}{{ end }};{{ end }}",
+			context: map[string]interface{}{"a": []interface{}{
+				map[string]interface{}{"n": 1, "b": []string{"x"}},
+				map[string]interface{}{"n": 2, "b": []string{}},
+				map[string]interface{}{"n": 3, "b": []string{"x"}},
+			}},
+			expected: "1x;23x;",
+		},
+		{
+			name:     "Nested path through maps",
+			template: "{{ .user.name.first }} {{ .user.address.city }}",
+			context: map[string]interface{}{"user": map[string]interface{}{
+				"name":    map[string]interface{}{"first": "Ada"},
+				"address": map[string]string{"city": "London"},
+			}},
+			expected: "Ada London",
+		},
+		{
+			name:     "Nested path through structs and pointers",
+			template: "{{ .order.Customer.Name }} {{ .order.Customer.Address.City }}",
+			context: map[string]interface{}{"order": &testOrder{
+				Customer: &testCustomer{Name: "Ada", Address: testAddress{City: "London"}},
+			}},
+			expected: "Ada London",
+		},
+		{
+			name:     "Nested path through nil pointer",
+			template: "{{ if .order.Customer.Name }}named{{ else }}anonymous{{ end }}",
+			context:  map[string]interface{}{"order": testOrder{}},
+			expected: "anonymous",
+		},
+		{
+			name:     "Nested path through interface field",
+			template: "{{ .order.Meta.source }}",
+			context:  map[string]interface{}{"order": testOrder{Meta: map[string]string{"source": "web"}}},
+			expected: "web",
+		},
+		{
+			name:     "Nested path in loop item",
+			template: "{{ range .orders }}{{ .Customer.Address.City }};{{ end }}",
+			context: map[string]interface{}{"orders": []testOrder{
+				{Customer: &testCustomer{Address: testAddress{City: "Paris"}}},
+				{Customer: &testCustomer{Address: testAddress{City: "Rome"}}},
+			}},
+			expected: "Paris;Rome;",
+		},
+		{
+			name:     "Nested path on variable",
+			template: "{{ $c := .order.Customer }}{{ $c.Address.City }}",
+			context:  map[string]interface{}{"order": testOrder{Customer: &testCustomer{Address: testAddress{City: "Oslo"}}}},
+			expected: "Oslo",
+		},
+		{
+			name:     "Nested path as function argument",
+			template: "{{ upper(.user.name.first) }}",
+			context:  map[string]interface{}{"user": map[string]interface{}{"name": map[string]string{"first": "ada"}}},
+			expected: "ADA",
+		},
+		{
+			name:     "Unexported field is not resolved",
+			template: "{{ if .c.secret }}leaked{{ else }}hidden{{ end }}",
+			context:  map[string]interface{}{"c": testCustomer{secret: "x"}},
+			expected: "hidden",
+		},
+		{
+			name:     "Index slice",
+			template: "{{ .items[0] }} {{ .items[1 + 1] }}",
+			context:  map[string]interface{}{"items": []interface{}{"a", "b", "c"}},
+			expected: "a c",
+		},
+		{
+			name:     "Negative index",
+			template: "{{ .items[-1] }}",
+			context:  map[string]interface{}{"items": []string{"a", "b", "c"}},
+			expected: "c",
+		},
+		{
+			name:     "Index out of range",
+			template: "{{ .items[3] }}",
+			context:  map[string]interface{}{"items": []string{"a", "b", "c"}},
+			wantErr:  true,
+		},
+		{
+			name:     "Negative index out of range",
+			template: "{{ .items[-4] }}",
+			context:  map[string]interface{}{"items": []string{"a", "b", "c"}},
+			wantErr:  true,
+		},
+		{
+			name:     "Index with string",
+			template: `{{ .items["a"] }}`,
+			context:  map[string]interface{}{"items": []string{"a"}},
+			wantErr:  true,
+		},
+		{
+			name:     "Map key that is not an identifier",
+			template: `{{ .headers["content-type"] }}`,
+			context:  map[string]interface{}{"headers": map[string]string{"content-type": "text/html"}},
+			expected: "text/html",
+		},
+		{
+			name:     "Map key from variable",
+			template: `{{ $k := "b" }}{{ .m[$k] }}`,
+			context:  map[string]interface{}{"m": map[string]interface{}{"a": 1, "b": 2}},
+			expected: "2",
+		},
+		{
+			name:     "Map with integer keys",
+			template: `{{ .m[2024] }}`,
+			context:  map[string]interface{}{"m": map[int64]string{2024: "this year"}},
+			expected: "this year",
+		},
+		{
+			name:     "Missing map key",
+			template: `[{{ if .m["x"] }}x{{ end }}]`,
+			context:  map[string]interface{}{"m": map[string]int{}},
+			expected: "[]",
+		},
+		{
+			name:     "Index followed by field path",
+			template: "{{ .rows[1].Customer.Name }}",
+			context: map[string]interface{}{"rows": []testOrder{
+				{Customer: &testCustomer{Name: "Ada"}},
+				{Customer: &testCustomer{Name: "Grace"}},
+			}},
+			expected: "Grace",
+		},
+		{
+			name:     "Chained index",
+			template: "{{ .grid[1][0] }}",
+			context:  map[string]interface{}{"grid": [][]int{{1, 2}, {3, 4}}},
+			expected: "3",
+		},
+		{
+			name:     "Index in loop with loop index",
+			template: "{{ $scores := .scores }}{{ range $i, $n := .names }}{{ $n }}={{ $scores[$i] }} {{ end }}",
+			context:  map[string]interface{}{"names": []string{"a", "b"}, "scores": []int{1, 2}},
+			expected: "a=1 b=2 ",
+		},
+		{
+			name:     "Index as function argument",
+			template: "{{ upper(.names[0]) }}",
+			context:  map[string]interface{}{"names": []string{"ada"}},
+			expected: "ADA",
+		},
+		{
+			name:     "Index nil",
+			template: "[{{ if .missing[0] }}x{{ end }}]",
+			context:  map[string]interface{}{},
+			expected: "[]",
+		},
+		{
+			name:     "Missing key renders empty",
+			template: "Hello, {{ .name }}{{ .user.name }}{{ .items[0] }}!",
+			context:  map[string]interface{}{"items": []interface{}{nil}},
+			expected: "Hello, !",
+		},
+		{
+			name:     "Coalesce chain",
+			template: `Hi {{ .nickname ?? .name ?? "friend" }}, {{ .missing ?? .other ?? "friend" }}`,
+			context:  map[string]interface{}{"name": "Ada"},
+			expected: "Hi Ada, friend",
+		},
+		{
+			name:     "Coalesce keeps zero values",
+			template: `[{{ .count ?? 5 }}|{{ .title ?? "untitled" }}|{{ .on ?? true }}]`,
+			context:  map[string]interface{}{"count": 0, "title": "", "on": false},
+			expected: "[0||false]",
+		},
+		{
+			name:     "Coalesce binds looser than other operators",
+			template: `{{ .a ?? .b + 1 }} {{ .a ?? .b > 1 || false }} {{ (.a ?? 2) * 3 }}`,
+			context:  map[string]interface{}{"b": 2},
+			expected: "3 true 6",
+		},
+		{
+			name:     "Coalesce in pipeline and condition",
+			template: `{{ .nickname ?? .name | upper }}{{ if .vip ?? .staff }} *{{ end }}`,
+			context:  map[string]interface{}{"name": "ada", "staff": true},
+			expected: "ADA *",
+		},
+		{
+			name:     "Coalesce skips the right side",
+			template: `{{ .name ?? .items[5] }}`,
+			context:  map[string]interface{}{"name": "ok", "items": []int{}},
+			expected: "ok",
+		},
+		{
+			name:     "Default function",
+			template: `{{ default(.title, "untitled") }} {{ .count | default(1) }} {{ .tags | default("none") }}`,
+			context:  map[string]interface{}{"title": "", "count": 0, "tags": []string{}},
+			expected: "untitled 0 none",
+		},
+		{
+			name:     "Missing key as function argument",
+			template: `{{ upper(.missing) }}|{{ default(.missing, "-") }}`,
+			context:  map[string]interface{}{},
+			expected: "|-",
+		},
+		{
+			name:     "Root path inside loop",
+			template: "{{ range .users }}{{ .name }}@{{ $.name }} {{ end }}",
+			context: map[string]interface{}{
+				"name":  "root",
+				"users": []interface{}{map[string]interface{}{"name": "Alice"}},
+			},
+			expected: "Alice@root ",
+		},
+		{
+			name:     "Root is the context",
+			template: "{{ range .items }}{{ $r := $ }}{{ $r.title }}{{ end }}",
+			context:  map[string]interface{}{"title": "T", "items": []int{1}},
+			expected: "T",
+		},
+		{
+			name:     "Root path with index",
+			template: "{{ range $i, $n := .names }}{{ $n }}={{ $.scores[$i] }} {{ end }}",
+			context:  map[string]interface{}{"names": []string{"a", "b"}, "scores": []int{1, 2}},
+			expected: "a=1 b=2 ",
+		},
+		{
+			name:     "Root path as range source",
+			template: "{{ range .outer }}{{ range $.inner }}{{ . }}{{ end }}{{ end }}",
+			context:  map[string]interface{}{"outer": []int{1, 2}, "inner": []string{"x"}},
+			expected: "xx",
+		},
+		{
+			name:     "Root path in condition and function",
+			template: "{{ range .items }}{{ if $.show }}{{ upper($.prefix) }}{{ . }}{{ end }}{{ end }}",
+			context:  map[string]interface{}{"show": true, "prefix": "p", "items": []string{"a"}},
+			expected: "Pa",
+		},
+		{
+			name:     "Outer loop item through named binding",
+			template: "{{ range $g := .groups }}{{ range .members }}{{ $g.name }}/{{ .name }} {{ end }}{{ end }}",
+			context: map[string]interface{}{"groups": []interface{}{
+				map[string]interface{}{"name": "admins", "members": []interface{}{
+					map[string]interface{}{"name": "Alice"},
+					map[string]interface{}{"name": "Bob"},
+				}},
+			}},
+			expected: "admins/Alice admins/Bob ",
+		},
+		{
+			name:     "Cannot assign to root",
+			template: "{{ $ = 1 }}",
+			context:  map[string]interface{}{},
+			wantErr:  true,
+		},
+		{
+			name:     "With rebinds dot",
+			template: "{{ with .order.Customer.Address }}{{ .City }}{{ end }}",
+			context:  map[string]interface{}{"order": testOrder{Customer: &testCustomer{Address: testAddress{City: "Oslo"}}}},
+			expected: "Oslo",
+		},
+		{
+			name:     "With skips empty value",
+			template: "[{{ with .missing }}{{ . }}{{ end }}]",
+			context:  map[string]interface{}{},
+			expected: "[]",
+		},
+		{
+			name:     "With else",
+			template: "{{ with .name }}Hi {{ . }}{{ else }}Anonymous{{ end }}",
+			context:  map[string]interface{}{"name": ""},
+			expected: "Anonymous",
+		},
+		{
+			name:     "With else keeps outer dot",
+			template: "{{ with .user }}{{ .name }}{{ else }}{{ .fallback }}{{ end }}",
+			context:  map[string]interface{}{"fallback": "guest"},
+			expected: "guest",
+		},
+		{
+			name:     "With binds variable",
+			template: "{{ with $c := .customer }}{{ $c.name }}/{{ .name }}{{ end }}",
+			context:  map[string]interface{}{"customer": map[string]interface{}{"name": "Ada"}},
+			expected: "Ada/Ada",
+		},
+		{
+			name:     "Dot restored after with",
+			template: "{{ range .users }}{{ with .address }}{{ .city }}{{ end }}-{{ .name }} {{ end }}",
+			context: map[string]interface{}{"users": []interface{}{
+				map[string]interface{}{"name": "Ada", "address": map[string]interface{}{"city": "London"}},
+				map[string]interface{}{"name": "Bob"},
+			}},
+			expected: "London-Ada -Bob ",
+		},
+		{
+			name:     "Range inside with",
+			template: "{{ with .team }}{{ range .members }}{{ . }}{{ end }}:{{ .name }}{{ end }}",
+			context:  map[string]interface{}{"team": map[string]interface{}{"name": "core", "members": []string{"a", "b"}}},
+			expected: "ab:core",
+		},
+		{
+			name:     "Root path inside with",
+			template: "{{ with .user }}{{ .name }}@{{ $.site }}{{ end }}",
+			context:  map[string]interface{}{"site": "example", "user": map[string]interface{}{"name": "Ada"}},
+			expected: "Ada@example",
+		},
+		{
+			name:     "Break and continue inside with",
+			template: "{{ range .rows }}{{ with .next }}{{ if . == 2 }}{{ continue }}{{ end }}{{ if . == 4 }}{{ break }}{{ end }}{{ . }}{{ end }}{{ .id }};{{ end }}",
+			context: map[string]interface{}{"rows": []interface{}{
+				map[string]interface{}{"id": "a", "next": 1},
+				map[string]interface{}{"id": "b", "next": 2},
+				map[string]interface{}{"id": "c", "next": 3},
+				map[string]interface{}{"id": "d", "next": 4},
+				map[string]interface{}{"id": "e", "next": 5},
+			}},
+			expected: "1a;3c;",
+		},
+		{
+			name:     "Unclosed with",
+			template: "{{ with .a }}",
+			context:  map[string]interface{}{},
+			wantErr:  true,
+		},
+		{
+			name:     "Range over typed slice of maps",
+			template: "{{ range .users }}{{ .name }} {{ end }}",
+			context: map[string]interface{}{"users": []map[string]interface{}{
+				{"name": "Alice"},
+				{"name": "Bob"},
+			}},
+			expected: "Alice Bob ",
+		},
+		{
+			name:     "Range over int slice",
+			template: "{{ range $i, $n := .nums }}{{ $i }}:{{ $n * 2 }}{{ if !$loop.last }},{{ end }}{{ end }}",
+			context:  map[string]interface{}{"nums": []int{1, 2, 3}},
+			expected: "0:2,1:4,2:6",
+		},
+		{
+			name:     "Range over array",
+			template: "{{ range .letters }}{{ . }}{{ end }}",
+			context:  map[string]interface{}{"letters": [3]string{"a", "b", "c"}},
+			expected: "abc",
+		},
+		{
+			name:     "Range over array pointer",
+			template: "{{ range .letters }}{{ . }}{{ end }}",
+			context:  map[string]interface{}{"letters": &[3]string{"a", "b", "c"}},
+			expected: "abc",
+		},
+		{
+			name:     "Range over channel",
+			template: "{{ range $i, $n := .ch }}{{ $i }}={{ $n }}{{ if $loop.last }}.{{ else }},{{ end }}{{ end }}",
+			context:  map[string]interface{}{"ch": closedChannel(4, 5, 6)},
+			expected: "0=4,1=5,2=6.",
+		},
+		{
+			name:     "Range over iter.Seq",
+			template: "{{ range .seq }}{{ . }}{{ if !$loop.last }}-{{ end }}{{ end }}",
+			context:  map[string]interface{}{"seq": slices.Values([]string{"a", "b", "c"})},
+			expected: "a-b-c",
+		},
+		{
+			name:     "Range over iter.Seq2",
+			template: "{{ range $k, $v := .seq }}{{ $k }}{{ $v }}{{ end }}",
+			context:  map[string]interface{}{"seq": maps.All(map[string]int{"a": 1})},
+			expected: "a1",
+		},
+		{
+			name:     "Range over iter.Seq of any",
+			template: "{{ range .seq }}{{ .name }}{{ end }}",
+			context: map[string]interface{}{"seq": iter.Seq[any](func(yield func(any) bool) {
+				yield(map[string]interface{}{"name": "Alice"})
+			})},
+			expected: "Alice",
+		},
 	}
 
 	engine := NewEngine()
@@ -51,3 +813,636 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteDoesNotModifyContext(t *testing.T) {
+	context := map[string]interface{}{"name": "Ada"}
+	engine := NewEngine()
+	result, err := engine.Execute("{{ $name := \"Bob\" }}{{ $name }} {{ .name }}", context)
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if string(result) != "Bob Ada" {
+		t.Errorf("Execute() = %q, want %q", result, "Bob Ada")
+	}
+	if len(context) != 1 || context["name"] != "Ada" {
+		t.Errorf("context was modified: %v", context)
+	}
+}
+
+type testAddress struct {
+	City string
+}
+
+type testCustomer struct {
+	Name    string
+	Address testAddress
+	secret  string
+}
+
+type testOrder struct {
+	Customer *testCustomer
+	Meta     interface{}
+}
+
+func closedChannel(items ...int) <-chan int {
+	ch := make(chan int, len(items))
+	for _, item := range items {
+		ch <- item
+	}
+	close(ch)
+	return ch
+}
+
+func TestExecuteStopsAbandonedIterator(t *testing.T) {
+	stopped := false
+	seq := func(yield func(int) bool) {
+		defer func() { stopped = true }()
+		for i := 0; ; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+
+	engine := NewEngine()
+	_, err := engine.Execute("{{ range .seq }}{{ if . == 2 }}{{ 1 / 0 }}{{ end }}{{ end }}", map[string]interface{}{"seq": iter.Seq[int](seq)})
+	if err == nil {
+		t.Fatal("Execute() expected an error")
+	}
+	if !stopped {
+		t.Error("iterator was not stopped")
+	}
+}
+
+func TestExecuteRangeErrors(t *testing.T) {
+	engine := NewEngine()
+	context := map[string]interface{}{
+		"name":   "Ada",
+		"count":  3,
+		"outbox": make(chan<- int),
+	}
+	for _, template := range []string{
+		"{{ range .name }}x{{ end }}",
+		"{{ range .count }}x{{ end }}",
+		"{{ range .outbox }}x{{ end }}",
+		"{{ range upper(.name) }}x{{ end }}",
+	} {
+		_, err := engine.Execute(template, context)
+		if err == nil || !strings.Contains(err.Error(), "cannot range over") || strings.Contains(err.Error(), "panic") {
+			t.Errorf("Execute(%q) error = %v, want a range error", template, err)
+		}
+	}
+}
+
+type testBase struct {
+	ID int `swap:"id"`
+}
+
+type testPage struct {
+	testBase
+	Title    string `swap:"title"`
+	Author   *testCustomer
+	Internal string `swap:"-"`
+	Items    []string
+}
+
+type testProfile struct {
+	Name     string
+	Nickname *string
+	Manager  *testCustomer
+	Tags     []string
+	Labels   map[string]string
+}
+
+func TestExecuteStructContext(t *testing.T) {
+	page := testPage{
+		testBase: testBase{ID: 7},
+		Title:    "Home",
+		Author:   &testCustomer{Name: "Ada", Address: testAddress{City: "London"}},
+		Internal: "secret",
+		Items:    []string{"a", "b"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		context  any
+		expected string
+	}{
+		{name: "Tagged field", template: "{{ .title }}", context: page, expected: "Home"},
+		{name: "Tagged field is not found by Go name", template: "[{{ if .Title }}x{{ end }}]", context: page, expected: "[]"},
+		{name: "Pointer context", template: "{{ .title }}", context: &page, expected: "Home"},
+		{name: "Promoted field", template: "{{ .id }}", context: page, expected: "7"},
+		{name: "Untagged nested field", template: "{{ .Author.Address.City }}", context: page, expected: "London"},
+		{name: "Skipped field", template: "[{{ if .Internal }}x{{ end }}]", context: page, expected: "[]"},
+		{name: "Range over struct field", template: "{{ range .Items }}{{ . }}{{ end }}", context: page, expected: "ab"},
+		{name: "Dot is the struct", template: "{{ $p := . }}{{ $p.title }}", context: &page, expected: "Home"},
+		{name: "Bare identifier", template: "{{ title }}", context: page, expected: "Home"},
+		{name: "Typed map context", template: "{{ .name }}", context: map[string]string{"name": "World"}, expected: "World"},
+		{name: "Nil context", template: "[{{ if .name }}x{{ end }}]", context: nil, expected: "[]"},
+		{name: "Nil pointer field prints empty", template: "[{{ .Nickname }}{{ .Manager }}{{ .Tags }}{{ .Labels }}]", context: testProfile{Name: "Ada"}, expected: "[]"},
+		{name: "Coalesce skips nil pointer field", template: "{{ .Nickname ?? .Name }}", context: testProfile{Name: "Ada"}, expected: "Ada"},
+		{name: "Coalesce skips nil map and slice", template: "{{ .Labels ?? .Tags ?? \"none\" }}", context: &testProfile{}, expected: "none"},
+		{name: "Coalesce and default agree", template: "{{ .Manager ?? \"-\" }} {{ .Manager | default(\"-\") }}", context: testProfile{}, expected: "- -"},
+	}
+
+	engine := NewEngine()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Execute(tt.template, tt.context)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Execute() = %v, want %v", string(result), tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteWithFuncs(t *testing.T) {
+	engine := NewEngine(WithFuncs(map[string]any{
+		"greet":  func(name string) string { return "Hello, " + name },
+		"add":    func(a, b int) int { return a + b },
+		"scale":  func(f float64, by int32) float64 { return f * float64(by) },
+		"upper":  func(s string) string { return "custom " + s },
+		"isNil":  func(v any) bool { return v == nil },
+		"labels": func(m map[string]string) int { return len(m) },
+		"joinAll": func(sep string, parts ...string) string {
+			return strings.Join(parts, sep)
+		},
+		"sum": func(nums ...int) int {
+			total := 0
+			for _, n := range nums {
+				total += n
+			}
+			return total
+		},
+		"ten":     func(a, b, c, d, e, f, g, h, i, j int) int { return a + b + c + d + e + f + g + h + i + j },
+		"version": func() string { return "v2" },
+	}))
+
+	tests := []struct {
+		name     string
+		template string
+		context  map[string]interface{}
+		expected string
+		wantErr  bool
+	}{
+		{name: "String function", template: "{{ greet(.name) }}", context: map[string]interface{}{"name": "Ada"}, expected: "Hello, Ada"},
+		{name: "Integer literals convert to int", template: "{{ add(1, 2) }}", expected: "3"},
+		{name: "Numeric conversion", template: "{{ scale(.f, 2) }}", context: map[string]interface{}{"f": 1.5}, expected: "3"},
+		{name: "Result used in expression", template: "{{ if add(.n, 1) > 2 }}big{{ end }}", context: map[string]interface{}{"n": 2}, expected: "big"},
+		{name: "Pipeline into custom function", template: "{{ .name | greet }}", context: map[string]interface{}{"name": "Bob"}, expected: "Hello, Bob"},
+		{name: "Custom function replaces builtin", template: "{{ upper(.name) }}", context: map[string]interface{}{"name": "x"}, expected: "custom x"},
+		{name: "Builtins still available", template: "{{ lower(.name) }}", context: map[string]interface{}{"name": "X"}, expected: "x"},
+		{name: "Nil argument", template: "{{ isNil(.missing) }}", expected: "true"},
+		{name: "Typed map argument", template: "{{ labels(.m) }}", context: map[string]interface{}{"m": map[string]string{"a": "b"}}, expected: "1"},
+		{name: "Bare function without parameters", template: "{{ version }} {{ greet(version) }}", context: map[string]interface{}{"version": "ignored"}, expected: "v2 Hello, v2"},
+		{name: "Bare name of function with parameters is a key", template: "{{ greet }} {{ sum }}", context: map[string]interface{}{"greet": "hi", "sum": 3}, expected: "hi 3"},
+		{name: "Nested calls", template: "{{ greet(lower(lower(.name))) }}", context: map[string]interface{}{"name": "ADA"}, expected: "Hello, ada"},
+		{name: "Nested calls in several arguments", template: "{{ add(add(1, 2), add(.n, 4)) }}", context: map[string]interface{}{"n": 3}, expected: "10"},
+		{name: "Nested call in condition", template: "{{ if lower(.name) == \"ada\" }}yes{{ end }}", context: map[string]interface{}{"name": "ADA"}, expected: "yes"},
+		{name: "Nested call in assignment", template: "{{ $g := greet(lower(.name)) }}{{ $g }}!", context: map[string]interface{}{"name": "ADA"}, expected: "Hello, ada!"},
+		{name: "Nested call in pipeline stage", template: "{{ .n | add(add(1, 1)) }}", context: map[string]interface{}{"n": 1}, expected: "3"},
+		{name: "Call in index", template: "{{ .items[add(0, 1)] }}", context: map[string]interface{}{"items": []string{"a", "b"}}, expected: "b"},
+		{name: "Call with non-string result printed", template: "{{ add(1, 2) }}-{{ isNil(.x) }}", expected: "3-true"},
+		{name: "Variadic function", template: `{{ joinAll(", ", "a", "b", .c) }}`, context: map[string]interface{}{"c": "c"}, expected: "a, b, c"},
+		{name: "Variadic without variadic arguments", template: `[{{ joinAll(",") }}]`, expected: "[]"},
+		{name: "Variadic in pipeline", template: `{{ "-" | joinAll("x", "y") }}`, expected: "x-y"},
+		{name: "Many variadic arguments", template: "{{ sum(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12) }}", expected: "78"},
+		{name: "More than eight parameters", template: "{{ ten(1, 2, 3, 4, 5, 6, 7, 8, 9, sum(5, 5)) }}", expected: "55"},
+		{name: "Too few arguments for variadic", template: "{{ joinAll() }}", wantErr: true},
+		{name: "Too few arguments", template: "{{ ten(1, 2, 3) }}", wantErr: true},
+		{name: "Wrong argument type", template: "{{ greet(.n) }}", context: map[string]interface{}{"n": 1}, wantErr: true},
+		{name: "Wrong number of arguments", template: "{{ add(1) }}", wantErr: true},
+		{name: "Unknown function", template: "{{ shout(.name) }}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Execute(tt.template, tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Execute() = %v, want %v", string(result), tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteStringFuncs(t *testing.T) {
+	engine := NewEngine()
+	context := map[string]interface{}{
+		"name": "  ada lovelace ",
+		"tags": "go,templates,fast",
+		"body": "the quick brown fox",
+	}
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "Pipeline", template: "[{{ .name | trim | title | padRight(14) }}]", expected: "[Ada Lovelace  ]"},
+		{name: "Range over split", template: "{{ range split(.tags, \",\") }}<{{ . }}>{{ end }}", expected: "<go><templates><fast>"},
+		{name: "Join split", template: "{{ .tags | split(\",\") | join(\" | \") }}", expected: "go | templates | fast"},
+		{name: "Condition", template: "{{ if contains(.tags, \"go\") && !hasPrefix(.tags, \"x\") }}yes{{ end }}", expected: "yes"},
+		{name: "Truncate and replace", template: "{{ .body | replace(\" \", \"_\") | truncate(10) }}", expected: "the_quick…"},
+		{name: "Wrap and indent", template: "{{ .body | wrap(10) | indent(2) }}", expected: "  the quick\n  brown fox"},
+		{name: "Repeat", template: "{{ repeat(\"=\", 3) }}{{ padLeft(\"7\", 3) }}", expected: "===  7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Execute(tt.template, context)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Execute() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteMathFuncs(t *testing.T) {
+	engine := NewEngine()
+	context := map[string]interface{}{
+		"total":  1234567.891,
+		"count":  int64(3),
+		"ratio":  0.4567,
+		"amount": "19.5",
+		"scores": []float32{0.5, 1.25},
+	}
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "Fixed with thousands", template: "{{ .total | fixed(2) | thousands }}", expected: "1,234,567.89"},
+		{name: "Round and arithmetic", template: "{{ round(.total / .count) }}", expected: "411523"},
+		{name: "Percent", template: "{{ percent(.ratio, 1) }}", expected: "45.7%"},
+		{name: "Numeric string", template: "{{ ceil(.amount) + 1 }}", expected: "21"},
+		{name: "Min and max", template: "{{ min(.count, 2.5) }}/{{ max(.count, .amount) }}", expected: "2.5/19.5"},
+		{name: "Abs in condition", template: "{{ if abs(-.count) == 3 }}three{{ end }}", expected: "three"},
+		{name: "Float32 output", template: "{{ range .scores }}{{ . }};{{ end }}", expected: "0.5;1.25;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Execute(tt.template, context)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Execute() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteDateFuncs(t *testing.T) {
+	now := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC)
+	engine := NewEngine(WithClock(func() time.Time { return now }))
+	context := map[string]interface{}{
+		"created":  time.Date(2024, 5, 29, 22, 15, 0, 0, time.UTC),
+		"shipped":  "2024-05-31 18:00:00",
+		"zone":     "America/New_York",
+		"invalid":  "31/31/2024",
+		"deadline": now.Add(36 * time.Hour),
+	}
+	tests := []struct {
+		name     string
+		template string
+		expected string
+		wantErr  bool
+	}{
+		{name: "Time value", template: `{{ formatDate(.created, "2006-01-02 15:04") }}`, expected: "2024-05-29 22:15"},
+		{name: "Zone conversion", template: `{{ .created | inZone("Asia/Tokyo") | formatDate("Jan 2 15:04 MST") }}`, expected: "May 30 07:15 JST"},
+		{name: "Zone from context", template: `{{ .shipped | inZone(.zone) | formatDate("15:04") }}`, expected: "14:00"},
+		{name: "Add and subtract", template: `{{ .created | addDuration("2h") | formatDate("Jan 2") }} {{ .created | addDuration("-1d") | formatDate("Jan 2") }}`, expected: "May 30 May 28"},
+		{name: "Relative", template: `{{ relative(.created) }}, {{ relative(.shipped) }}, {{ relative(.deadline) }}`, expected: "2 days ago, 15 hours ago, in 1 day"},
+		{name: "Now", template: `{{ now() | formatDate("2006") }}`, expected: "2024"},
+		{name: "Bare now", template: `{{ now }}|{{ now | formatDate("2006") }}|{{ if now }}set{{ end }}`, expected: "2024-06-01 09:00:00 +0000 UTC|2024|set"},
+		{name: "Bare now as argument", template: `{{ formatDate(now, "Jan 2") }}`, expected: "Jun 1"},
+		{name: "Invalid date", template: `{{ formatDate(.invalid, "2006") }}`, wantErr: true},
+		{name: "Unknown zone", template: `{{ inZone(.created, "Nowhere/City") }}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Execute(tt.template, context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Execute() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExecuteNowWithDefaultClock(t *testing.T) {
+	result, err := NewEngine().Execute("{{ now }}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(result), "m=") {
+		t.Errorf("Execute() = %q, want no monotonic clock reading", result)
+	}
+	if _, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", string(result)); err != nil {
+		t.Errorf("Execute() = %q, want a printed time: %v", result, err)
+	}
+}
+
+func TestExecuteCollectionFuncs(t *testing.T) {
+	engine := NewEngine()
+	context := map[string]interface{}{
+		"tags": []string{"go", "fast", "templates", "bytecode"},
+		"orders": []testOrder{
+			{Customer: &testCustomer{Name: "Carol", Address: testAddress{City: "Paris"}}},
+			{Customer: &testCustomer{Name: "Alice", Address: testAddress{City: "Oslo"}}},
+			{Customer: &testCustomer{Name: "Bob", Address: testAddress{City: "Paris"}}},
+		},
+		"scores": map[string]int{"b": 2, "a": 9, "c": 5},
+		"empty":  []int{},
+	}
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "Len", template: "{{ len(.tags) }} {{ len(.scores) }} {{ len(.missing) }}", expected: "4 3 0"},
+		{name: "Len in condition", template: "{{ if len(.empty) == 0 }}none{{ end }}", expected: "none"},
+		{name: "First three", template: `{{ first(.tags, 3) | join(", ") }}`, expected: "go, fast, templates"},
+		{name: "First and last", template: "{{ first(.tags) }}..{{ last(.tags) }}", expected: "go..bytecode"},
+		{name: "Sort and reverse", template: `{{ .tags | sort | reverse | join(" ") }}`, expected: "templates go fast bytecode"},
+		{name: "Slice", template: `{{ range slice(.tags, 1, -1) }}[{{ . }}]{{ end }}`, expected: "[fast][templates]"},
+		{name: "Map values", template: `{{ sort(.scores) | join(",") }}`, expected: "2,5,9"},
+		{name: "Sort by path", template: `{{ range sortBy(.orders, "Customer.Name") }}{{ .Customer.Name }} {{ end }}`, expected: "Alice Bob Carol "},
+		{name: "Group by path", template: `{{ range groupBy(.orders, ".Customer.Address.City") }}{{ .key }}:{{ range .items }} {{ .Customer.Name }}{{ end }} ({{ len(.items) }}); {{ end }}`, expected: "Paris: Carol Bob (2); Oslo: Alice (1); "},
+		{name: "Group with loop metadata", template: `{{ range $g := groupBy(sortBy(.orders, "Customer.Address.City"), "Customer.Address.City") }}{{ $loop.index }}={{ $g.key }}{{ end }}`, expected: "1=Oslo2=Paris"},
+		{name: "Join missing value", template: `{{ join(.ch, "+") }}`, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Execute(tt.template, context)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if string(result) != tt.expected {
+				t.Errorf("Execute() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+
+	result, err := engine.Execute(`{{ join(.ch, "+") }}`, map[string]interface{}{"ch": closedChannel(1, 2, 3)})
+	if err != nil || string(result) != "1+2+3" {
+		t.Errorf("Execute() = %q, %v, want %q", result, err, "1+2+3")
+	}
+}
+
+func TestWithStringFuncsDisabled(t *testing.T) {
+	engine := NewEngine(WithStringFuncs(false), WithFuncs(map[string]any{
+		"trim": func(s string) string { return "<" + s + ">" },
+	}))
+	if _, err := engine.Execute("{{ wrap(.a, 10) }}", nil); err == nil {
+		t.Error("Execute() expected an unknown function error")
+	}
+	result, err := engine.Execute("{{ trim(\"a\") }}{{ upper(\"b\") }}", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "<a>B" {
+		t.Errorf("Execute() = %q, want %q", result, "<a>B")
+	}
+}
+
+var errNotFound = errors.New("not found")
+
+func TestExecuteFuncErrors(t *testing.T) {
+	engine := NewEngine(WithFuncs(map[string]any{
+		"find": func(id int) (string, error) {
+			if id != 1 {
+				return "", errNotFound
+			}
+			return "one", nil
+		},
+		"explode": func(s string) string { panic("exploded") },
+	}))
+
+	tests := []struct {
+		name     string
+		template string
+		context  map[string]interface{}
+		fn       string
+		line     int
+		target   error
+	}{
+		{name: "Returned error", template: "{{ find(2) }}", fn: "find", line: 1, target: errNotFound},
+		{name: "Error on later line", template: "a\n{{ if true }}\n\n  {{ find(1) }} {{ find(.id) }}\n{{ end }}", context: map[string]interface{}{"id": 3}, fn: "find", line: 4, target: errNotFound},
+		{name: "Error in pipeline", template: "{{ 2\n| find }}", fn: "find", line: 2, target: errNotFound},
+		{name: "Nested call", template: "{{ upper(find(0)) }}", fn: "find", line: 1, target: errNotFound},
+		{name: "Invalid date", template: "{{ formatDate(.d, \"2006\") }}", context: map[string]interface{}{"d": "soon"}, fn: "formatDate", line: 1},
+		{name: "Panicking function", template: "x{{ explode(\"a\") }}", fn: "explode", line: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Execute(tt.template, tt.context)
+			if err == nil {
+				t.Fatalf("Execute() = %q, want error", result)
+			}
+			var funcErr *FuncError
+			if !errors.As(err, &funcErr) {
+				t.Fatalf("Execute() error = %v, want a *FuncError", err)
+			}
+			if funcErr.Func != tt.fn || funcErr.Line != tt.line {
+				t.Errorf("FuncError = %s at line %d, want %s at line %d", funcErr.Func, funcErr.Line, tt.fn, tt.line)
+			}
+			if tt.target != nil && !errors.Is(err, tt.target) {
+				t.Errorf("Execute() error = %v, want it to wrap %v", err, tt.target)
+			}
+		})
+	}
+
+	if result, err := engine.Execute("{{ find(1) }}", nil); err != nil || string(result) != "one" {
+		t.Errorf("Execute() = %q, %v, want \"one\"", result, err)
+	}
+}
+
+func TestExecuteSyntaxErrors(t *testing.T) {
+	engine := NewEngine()
+	for _, template := range []string{
+		"{{ .name # }}",
+		"{{ upper(\"abc) }}",
+		"{{ .a & .b }}",
+	} {
+		if _, err := engine.Execute(template, nil); err == nil {
+			t.Errorf("Execute(%q) expected an error", template)
+		}
+	}
+}
+
+func TestWithFuncsRejectsInvalidFunctions(t *testing.T) {
+	tests := map[string]any{
+		"not a function": "upper",
+		"no results":     func(string) {},
+		"two results":    func(string) (string, string) { return "", "" },
+	}
+
+	for name, fn := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("NewEngine() did not panic")
+				}
+			}()
+			NewEngine(WithFuncs(map[string]any{"fn": fn}))
+		})
+	}
+}
+
+func TestRunRefusesProgramOfAnotherEngine(t *testing.T) {
+	engine := NewEngine()
+	program, err := engine.Compile("{{ abs(-2) }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := engine.Run(program, nil); err != nil || string(result) != "2" {
+		t.Errorf("Run() = %q, %v, want \"2\"", result, err)
+	}
+
+	other := NewEngine(WithFuncs(map[string]any{"aaa": func() string { return "aaa" }}))
+	if result, err := other.Run(program, nil); err == nil {
+		t.Errorf("Run() on another engine = %q, want an error", result)
+	}
+}
+
+func TestExecuteMissingKeyPolicies(t *testing.T) {
+	type profile struct {
+		Name string
+	}
+	context := map[string]interface{}{
+		"user":    map[string]interface{}{"name": "Ada", "nickname": nil},
+		"profile": profile{Name: "Ada"},
+		"items":   []interface{}{map[string]interface{}{"sku": "a1"}},
+		"empty":   nil,
+	}
+
+	tests := []struct {
+		name        string
+		template    string
+		empty       string
+		placeholder string
+		fail        string
+	}{
+		{name: "Present key", template: "{{ .user.name }}", empty: "Ada", placeholder: "Ada", fail: "Ada"},
+		{name: "Nil value", template: "[{{ .user.nickname }}{{ .empty }}]", empty: "[]", placeholder: "[]", fail: "[]"},
+		{name: "Missing key", template: "Hi {{ .user.email }}!", empty: "Hi !", placeholder: "Hi [missing .user.email]!", fail: "line 1: missing key .user.email"},
+		{name: "Missing parent", template: "{{ .account.owner.name }}", empty: "", placeholder: "[missing .account.owner.name]", fail: "line 1: missing key .account.owner.name"},
+		{name: "Below nil value", template: "{{ .empty.name }}", empty: "", placeholder: "[missing .empty.name]", fail: "line 1: missing key .empty.name"},
+		{name: "Missing struct field", template: "{{ .profile.Phone }}", empty: "", placeholder: "[missing .profile.Phone]", fail: "line 1: missing key .profile.Phone"},
+		{name: "Missing key in expression", template: "a\n{{ upper(.user.role) }}", empty: "a\n", placeholder: "a\n", fail: "line 2: missing key .user.role"},
+		{name: "Missing key in condition", template: "{{ if .user.admin }}admin{{ else }}user{{ end }}", empty: "user", placeholder: "user", fail: "line 1: missing key .user.admin"},
+		{name: "Missing loop key", template: "\n\n{{ range .orders }}x{{ else }}none{{ end }}", empty: "\n\nnone", placeholder: "\n\nnone", fail: "line 3: missing key .orders"},
+		{name: "Missing key in loop item", template: "{{ range .items }}{{ .sku }} {{ .price }}{{ end }}", empty: "a1 ", placeholder: "a1 [missing .price]", fail: "line 1: missing key .price"},
+		{name: "Variable field", template: "{{ $u := .user }}{{ $u.name }} {{ $u.nickname }}|{{ $u.q }}", empty: "Ada |", placeholder: "Ada |[missing $u.q]", fail: "line 1: missing key $u.q"},
+		{name: "Field after subscript", template: "{{ .items[0].sku }}\n{{ .items[0].q }}", empty: "a1\n", placeholder: "a1\n[missing .items[0].q]", fail: "line 2: missing key .items[0].q"},
+		{name: "Subscript key", template: "{{ .user[\"name\"] }}{{ .user[\"nickname\"] }}|{{ .user[\"role\"] }}", empty: "Ada|", placeholder: "Ada|[missing .user[\"role\"]]", fail: "line 1: missing key .user[\"role\"]"},
+		{name: "Field after subscript in expression", template: "{{ upper(.items[0].q) }}", empty: "", placeholder: "", fail: "line 1: missing key .items[0].q"},
+		{name: "Variable field in condition", template: "{{ $u := .user }}{{ if $u.admin }}admin{{ else }}user{{ end }}", empty: "user", placeholder: "user", fail: "line 1: missing key $u.admin"},
+		{name: "Coalesce over variable field", template: "{{ $u := .user }}{{ .nope ?? $u.q ?? .items[0].q ?? .user[\"role\"] ?? \"none\" }}", empty: "none", placeholder: "none", fail: "none"},
+		{name: "Default over subscript", template: "{{ default(.items[0].q, \"-\") }} {{ .user[\"role\"] | default(\"guest\") }}", empty: "- guest", placeholder: "- guest", fail: "- guest"},
+		{name: "Root path", template: "{{ with .user }}{{ $.title }}{{ end }}", empty: "", placeholder: "[missing $.title]", fail: "line 1: missing key $.title"},
+		{name: "Root path at top level", template: "{{ $.nope }}|{{ $.user.name }}", empty: "|Ada", placeholder: "[missing $.nope]|Ada", fail: "line 1: missing key $.nope"},
+		{name: "Nested root path", template: "{{ with .items }}{{ if $.user.nope }}x{{ end }}{{ end }}", empty: "", placeholder: "", fail: "line 1: missing key $.user.nope"},
+		{name: "Nil literal", template: "{{ if .user.nickname == nil }}none{{ end }}", empty: "none", placeholder: "none", fail: "none"},
+		{name: "Coalesce", template: "{{ .user.email ?? .account.email ?? \"none\" }}", empty: "none", placeholder: "none", fail: "none"},
+		{name: "Default", template: "{{ default(.user.email, \"none\") }} {{ .account.name | default(\"anon\") }}", empty: "none anon", placeholder: "none anon", fail: "none anon"},
+		{name: "Default keeps other arguments strict", template: "{{ default(.user.email, .account.email) }}", empty: "", placeholder: "", fail: "line 1: missing key .account.email"},
+	}
+
+	policies := []struct {
+		name   string
+		policy MissingKeyPolicy
+	}{
+		{name: "empty", policy: MissingKeyEmpty},
+		{name: "placeholder", policy: MissingKeyPlaceholder},
+		{name: "fail", policy: MissingKeyFail},
+	}
+
+	for _, p := range policies {
+		engine := NewEngine(WithMissingKey(p.policy))
+		for _, tt := range tests {
+			t.Run(p.name+"/"+tt.name, func(t *testing.T) {
+				want := map[MissingKeyPolicy]string{
+					MissingKeyEmpty:       tt.empty,
+					MissingKeyPlaceholder: tt.placeholder,
+					MissingKeyFail:        tt.fail,
+				}[p.policy]
+
+				result, err := engine.Execute(tt.template, context)
+				var missing *MissingKeyError
+				if errors.As(err, &missing) {
+					if missing.Error() != want {
+						t.Errorf("Execute() error = %v, want %q", missing, want)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Execute() error = %v", err)
+				}
+				if string(result) != want {
+					t.Errorf("Execute() = %q, want %q", result, want)
+				}
+			})
+		}
+	}
+}
+
+func TestMissingKeyError(t *testing.T) {
+	engine := NewEngine(WithMissingKey(MissingKeyFail))
+	_, err := engine.Execute("Dear {{ .customer.name }},\n\nYour order {{ .order.number }} shipped.", map[string]interface{}{
+		"customer": map[string]interface{}{"name": "Ada"},
+	})
+	var missing *MissingKeyError
+	if !errors.As(err, &missing) {
+		t.Fatalf("Execute() error = %v, want a *MissingKeyError", err)
+	}
+	if missing.Path != ".order.number" || missing.Line != 3 {
+		t.Errorf("MissingKeyError = %s at line %d, want .order.number at line 3", missing.Path, missing.Line)
+	}
+}
+
+func TestProgramMissingKeyOverride(t *testing.T) {
+	engine := NewEngine(WithMissingKey(MissingKeyFail))
+	program, err := engine.Compile("Hi {{ .name }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := engine.Run(program, nil); err == nil {
+		t.Error("Run() with the engine policy expected an error")
+	}
+	program.MissingKey = MissingKeyPlaceholder
+	if result, err := engine.Run(program, nil); err != nil || string(result) != "Hi [missing .name]" {
+		t.Errorf("Run() = %q, %v, want \"Hi [missing .name]\"", result, err)
+	}
+
+	lenient := NewEngine()
+	program, err = lenient.Compile("Hi {{ .name }}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	program.MissingKey = MissingKeyFail
+	if _, err := lenient.Run(program, map[string]interface{}{}); err == nil {
+		t.Error("Run() with MissingKeyFail expected an error")
+	}
+	if result, err := lenient.Execute("Hi {{ .other }}", nil); err != nil || string(result) != "Hi " {
+		t.Errorf("Execute() = %q, %v, want \"Hi \"", result, err)
+	}
+}